@@ -2,9 +2,6 @@ module github.com/lende/127
 
 go 1.21
 
-require (
-	github.com/kevinburke/hostsfile v0.0.0-20220522040509-e5e984885321
-	golang.org/x/net v0.17.0
-)
+require golang.org/x/net v0.17.0
 
 require golang.org/x/text v0.13.0 // indirect
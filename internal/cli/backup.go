@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/lende/127/lib127"
+)
+
+func (a App) listBackups(cmd command) int {
+	hosts, err := a.openBackups(cmd)
+	if err != nil {
+		return a.error(cmd, err)
+	}
+	defer func() { _ = hosts.Close() }()
+
+	backups, err := hosts.Backups()
+	if err != nil {
+		return a.error(cmd, err)
+	}
+	for _, b := range backups {
+		fmt.Fprintln(a.writer(), b.Name)
+	}
+	return StatusSuccess
+}
+
+// undo restores the backup named by cmd, or the newest backup, and prints its
+// name.
+func (a App) undo(cmd command) int {
+	hosts, err := a.openBackups(cmd)
+	if err != nil {
+		return a.error(cmd, err)
+	}
+	defer func() { _ = hosts.Close() }()
+
+	if len(cmd.hostnames) > 0 {
+		cmd.backup = cmd.hostnames[0]
+	} else {
+		backups, err := hosts.Backups()
+		if err != nil {
+			return a.error(cmd, err)
+		}
+		if len(backups) == 0 {
+			fmt.Fprintf(a.errorWriter(), "%s: no backups of %s\n", a.name(), cmd.filename)
+			return StatusFailure
+		}
+		cmd.backup = backups[0].Name
+	}
+
+	if err := hosts.Restore(cmd.backup); err != nil {
+		return a.error(cmd, err)
+	}
+
+	if cmd.dryRun {
+		return a.printDiff(hosts, StatusSuccess)
+	}
+	if err := hosts.Save(); err != nil {
+		return a.error(cmd, err)
+	}
+	fmt.Fprintln(a.writer(), cmd.backup)
+	return StatusSuccess
+}
+
+func (a App) openBackups(cmd command) (*lib127.Hosts, error) {
+	return lib127.Open(cmd.filename, lib127.WithLockTimeout(cmd.lockTimeout),
+		lib127.WithBackups(cmd.backupDir, lib127.DefaultBackups))
+}
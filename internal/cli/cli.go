@@ -1,33 +1,45 @@
 package cli
 
 import (
+	"bufio"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"os/user"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/lende/127/lib127"
+	"github.com/lende/127/lib127/dns"
 )
 
-// Status codes returned by App to indicate sucess or failure.
+// Status codes returned by App to indicate sucess or failure. StatusChanged is
+// returned by dry runs that would change the hosts file.
 const (
 	StatusSuccess = 0
 	StatusFailure = 1
+	StatusChanged = 2
 )
 
 // App is a command-line interface to lib127.
 type App struct {
 	Name, Version       string
+	Reader              io.Reader
 	Writer, ErrorWriter io.Writer
+
+	// User and Now are recorded with the mappings the application makes. They
+	// default to the user running it and the current time.
+	User string
+	Now  func() time.Time
 }
 
-// Run runs the application with the given arguments. Returns 0 on success and 1
-// on failure.
+// Run runs the application with the given arguments. Returns 0 on success, 1 on
+// failure, and 2 if a dry run would change the hosts file.
 func (a App) Run(args ...string) int {
 	cmd := command{filename: lib127.DefaultHostsFile}
 	if ok := a.parse(args, &cmd); !ok {
@@ -51,6 +63,13 @@ func (a App) version() string {
 	return "0.0.0-dev"
 }
 
+func (a App) reader() io.Reader {
+	if a.Reader != nil {
+		return a.Reader
+	}
+	return os.Stdin
+}
+
 func (a App) writer() io.Writer {
 	if a.Writer != nil {
 		return a.Writer
@@ -65,41 +84,324 @@ func (a App) errorWriter() io.Writer {
 	return os.Stderr
 }
 
+func (a App) user() string {
+	if a.User != "" {
+		return a.User
+	}
+	return currentUser()
+}
+
+func (a App) now() time.Time {
+	if a.Now != nil {
+		return a.Now()
+	}
+	return time.Now()
+}
+
 type command struct {
 	printVersion       bool
 	filename, hostname string
-	unmap, echo        bool
+	hostnames          []string
+	unmap, echo, force bool
+	dryRun             bool
+	stdin, hashed      bool
+	ipv6, dualStack    bool
+	ip, alias          string
+
+	list, all bool
+	format    string
+
+	dns, serve bool
+	listen     string
+	tokenFile  string
+
+	backups, undo bool
+	backupDir     string
+	backup        string
+
+	doctor, fix bool
+
+	run     bool
+	command []string
+
+	gc  bool
+	ttl time.Duration
+
+	tag, note string
+
+	profile     bool
+	profileName string
+
+	pools       []lib127.Pool
+	lockTimeout time.Duration
 }
 
 func (a App) parse(args []string, cmd *command) bool {
 	const usageFmt = `%s is a tool for mapping hostnames to random loopback addresses.
 
-Usage: %s [option ...] [hostname]
-Print IP mapped to hostname, assigning a random IP if no mapping exists.
+Usage: %s [option ...] [hostname ...]
+       %s list [option ...]
+       %s dns [option ...]
+       %s serve [option ...]
+       %s backups [option ...]
+       %s undo [option ...] [backup]
+       %s doctor [option ...]
+       %s exec [option ...] -n hostname ... [--] command [argument ...]
+       %s gc [option ...]
+       %s profile [option ...] [action name]
+Print IP mapped to hostname, assigning a random IP if no mapping exists. Given
+several hostnames, print each IP followed by its hostname. Wildcard patterns,
+such as *.app.test, are resolved by the DNS server of the dns command. Use the
+list command to list existing mappings, and the serve command to manage them
+over HTTP. Changes to the hosts file are backed up, and can be reverted with the
+undo command. Use the doctor command to check the hosts file for problems, and
+the exec command to map hostnames for as long as a command runs. Mappings made
+with -T expire, and are removed by the gc command. Mappings record the user that
+made them, and when, along with the tag and note given by -g and -m. Use the
+profile command to manage the mappings made with -P together.
+
+Options:
+`
+
+	const listUsageFmt = `Usage: %s list [option ...]
+List hostnames mapped to loopback addresses.
+
+Options:
+`
+
+	const dnsUsageFmt = `Usage: %s dns [option ...]
+Serve DNS queries for mapped hostnames and wildcard patterns on a loopback
+address, until interrupted.
+
+Options:
+`
+
+	const serveUsageFmt = `Usage: %s serve [option ...]
+Serve a JSON API for listing, mapping and unmapping hostnames on a loopback
+address, until interrupted.
+
+Options:
+`
+
+	const backupsUsageFmt = `Usage: %s backups [option ...]
+List backups of the hosts file, newest first.
+
+Options:
+`
+
+	const undoUsageFmt = `Usage: %s undo [option ...] [backup]
+Restore the hosts file from the named backup, or from the newest backup, which
+holds the state before the last change. The replaced content is backed up in
+turn, so an undo can be undone as well.
+
+Options:
+`
+
+	const doctorUsageFmt = `Usage: %s doctor [option ...]
+Check the hosts file for problems, such as malformed lines, invalid hostnames or
+hostnames mapped more than once, and print them. Exits with status 1 if any
+problems remain. Use -x to remove mappings from the managed block that never
+take effect, since the hostname is mapped on an earlier line.
+
+Options:
+`
+
+	const execUsageFmt = `Usage: %s exec [option ...] -n hostname ... [--] command [argument ...]
+Map hostnames, and run command with their IPs in environment variables named
+after them, such as IP_SVC_TEST for svc.test. Signals are forwarded to command.
+Hostnames that were not mapped before are unmapped once command exits, whatever
+its outcome. Exits with the exit status of command, or 128 plus the number of
+the signal that killed it.
 
 Options:
 `
 
+	const gcUsageFmt = `Usage: %s gc [option ...]
+Remove expired mappings, made with -T, and print them.
+
+Options:
+`
+
+	const profileUsageFmt = `Usage: %s profile [option ...] [action name]
+Perform an action on every mapping of the named profile, made with -P, without
+touching other mappings. Print the names of the profiles if no action is given.
+Actions are:
+  list    print the mappings of the profile, marking those switched off
+  export  print the records of the profile in hosts file format
+  off     switch off the mappings of the profile, keeping their IPs reserved
+  on      switch the mappings of the profile back on
+  unmap   unmap the mappings of the profile
+
+Options:
+`
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			cmd.list, args = true, args[1:]
+		case "dns":
+			cmd.dns, args = true, args[1:]
+		case "serve":
+			cmd.serve, args = true, args[1:]
+		case "backups":
+			cmd.backups, args = true, args[1:]
+		case "undo":
+			cmd.undo, args = true, args[1:]
+		case "doctor":
+			cmd.doctor, args = true, args[1:]
+		case "exec":
+			cmd.run, args = true, args[1:]
+		case "gc":
+			cmd.gc, args = true, args[1:]
+		case "profile":
+			cmd.profile, args = true, args[1:]
+		}
+	}
+
 	flags := flag.NewFlagSet("127", flag.ContinueOnError)
 	flags.SetOutput(a.errorWriter())
 	flags.Usage = func() {
-		fmt.Fprintf(a.errorWriter(), usageFmt, a.name(), a.name())
+		switch {
+		case cmd.list:
+			fmt.Fprintf(a.errorWriter(), listUsageFmt, a.name())
+		case cmd.dns:
+			fmt.Fprintf(a.errorWriter(), dnsUsageFmt, a.name())
+		case cmd.serve:
+			fmt.Fprintf(a.errorWriter(), serveUsageFmt, a.name())
+		case cmd.backups:
+			fmt.Fprintf(a.errorWriter(), backupsUsageFmt, a.name())
+		case cmd.undo:
+			fmt.Fprintf(a.errorWriter(), undoUsageFmt, a.name())
+		case cmd.doctor:
+			fmt.Fprintf(a.errorWriter(), doctorUsageFmt, a.name())
+		case cmd.run:
+			fmt.Fprintf(a.errorWriter(), execUsageFmt, a.name())
+		case cmd.gc:
+			fmt.Fprintf(a.errorWriter(), gcUsageFmt, a.name())
+		case cmd.profile:
+			fmt.Fprintf(a.errorWriter(), profileUsageFmt, a.name())
+		default:
+			fmt.Fprintf(a.errorWriter(), usageFmt, a.name(), a.name(), a.name(), a.name(),
+				a.name(), a.name(), a.name(), a.name(), a.name(), a.name(), a.name())
+		}
 		flags.PrintDefaults()
 	}
 
-	flags.BoolVar(&cmd.printVersion, "v", false, "print version")
 	flags.StringVar(&cmd.filename, "f", lib127.DefaultHostsFile, "path to hosts file")
-	flags.BoolVar(&cmd.echo, "e", false, "echo hostname")
-	flags.BoolVar(&cmd.unmap, "u", false, "unmap hostname")
+	flags.DurationVar(&cmd.lockTimeout, "w", lib127.DefaultLockTimeout,
+		"time to wait for other processes to release hosts file")
+	if !cmd.list && !cmd.dns {
+		flags.StringVar(&cmd.backupDir, "B", "",
+			"keep backups in `dir` (default: hosts file path with .backups suffix)")
+	}
+	switch {
+	case cmd.list:
+		flags.BoolVar(&cmd.all, "a", false, "list all mappings, not only loopback")
+		flags.StringVar(&cmd.format, "o", formatTable, "output `format`: table, plain or json")
+		flags.StringVar(&cmd.tag, "g", "", "list only mappings tagged with `tag`")
+	case cmd.dns:
+		flags.StringVar(&cmd.listen, "l", dns.DefaultAddr, "loopback `address` to listen on")
+	case cmd.serve:
+		flags.StringVar(&cmd.listen, "l", defaultServeAddr, "loopback `address` to listen on")
+		flags.StringVar(&cmd.tokenFile, "k", "", "require bearer token read from `file`")
+	case cmd.backups:
+		// Only the common options apply.
+	case cmd.undo:
+		flags.BoolVar(&cmd.dryRun, "n", false,
+			"print changes as a unified diff instead of saving them")
+	case cmd.doctor:
+		flags.BoolVar(&cmd.fix, "x", false, "fix problems that can be fixed safely")
+		flags.BoolVar(&cmd.dryRun, "n", false,
+			"print fixes as a unified diff instead of saving them")
+	case cmd.run:
+		flags.Func("n", "map `hostname` while command runs", func(s string) error {
+			cmd.hostnames = append(cmd.hostnames, s)
+			return nil
+		})
+		allocationFlags(flags, cmd)
+	case cmd.gc, cmd.profile:
+		flags.BoolVar(&cmd.dryRun, "n", false,
+			"print changes as a unified diff instead of saving them")
+	default:
+		flags.BoolVar(&cmd.printVersion, "v", false, "print version")
+		flags.BoolVar(&cmd.echo, "e", false, "echo hostname")
+		flags.BoolVar(&cmd.unmap, "u", false, "unmap hostname")
+		flags.BoolVar(&cmd.force, "F", false, "force changes to records not managed by 127")
+		flags.BoolVar(&cmd.dryRun, "n", false,
+			"print changes as a unified diff instead of saving them")
+		flags.BoolVar(&cmd.stdin, "i", false, "read hostnames from stdin, one per line")
+		flags.StringVar(&cmd.alias, "A", "", "add hostnames as aliases sharing the IP of `hostname`")
+		flags.StringVar(&cmd.ip, "t", "", "map hostname to the given loopback `ip`")
+		flags.BoolVar(&cmd.dualStack, "b", false, "map to both IPv4 and IPv6 addresses")
+		flags.DurationVar(&cmd.ttl, "T", 0, "expire new mappings after `ttl`, such as 24h")
+		flags.StringVar(&cmd.tag, "g", "", "tag new mappings with `tag`, such as a project name")
+		flags.StringVar(&cmd.note, "m", "", "record `note` describing new mappings")
+		flags.StringVar(&cmd.profileName, "P", "", "add new mappings to profile `name`")
+		allocationFlags(flags, cmd)
+	}
 
 	if err := flags.Parse(args); err != nil {
 		return false
 	}
 
-	cmd.hostname = flags.Arg(0)
+	if cmd.list && !isFormat(cmd.format) {
+		fmt.Fprintf(a.errorWriter(), "%s: invalid output format: %s\n", a.name(), cmd.format)
+		return false
+	}
+
+	if cmd.run {
+		cmd.command = flags.Args()
+	} else {
+		cmd.hostnames = flags.Args()
+	}
+	if cmd.run && (len(cmd.hostnames) == 0 || len(cmd.command) == 0) {
+		fmt.Fprintf(a.errorWriter(), "%s: exec requires hostnames to map and a command to run\n",
+			a.name())
+		return false
+	}
+	if cmd.undo && len(cmd.hostnames) > 1 {
+		fmt.Fprintf(a.errorWriter(), "%s: undo takes at most one backup\n", a.name())
+		return false
+	}
+	if cmd.doctor && len(cmd.hostnames) > 0 {
+		fmt.Fprintf(a.errorWriter(), "%s: doctor takes no arguments\n", a.name())
+		return false
+	}
+	if cmd.gc && len(cmd.hostnames) > 0 {
+		fmt.Fprintf(a.errorWriter(), "%s: gc takes no arguments\n", a.name())
+		return false
+	}
+	if cmd.profile && len(cmd.hostnames) > 0 &&
+		(len(cmd.hostnames) != 2 || !isProfileAction(cmd.hostnames[0])) {
+		fmt.Fprintf(a.errorWriter(), "%s: profile takes an action and a profile name\n", a.name())
+		return false
+	}
+	if cmd.ip != "" && (len(cmd.hostnames) != 1 || cmd.stdin || cmd.unmap) {
+		fmt.Fprintf(a.errorWriter(), "%s: -t requires a single hostname to map\n", a.name())
+		return false
+	}
+	if cmd.alias != "" && (cmd.ip != "" || cmd.unmap) {
+		fmt.Fprintf(a.errorWriter(), "%s: -A can not be combined with -t or -u\n", a.name())
+		return false
+	}
 	return true
 }
 
+// allocationFlags defines the flags choosing how IPs are allocated to hostnames.
+func allocationFlags(flags *flag.FlagSet, cmd *command) {
+	flags.BoolVar(&cmd.hashed, "d", false, "derive IP from hostname instead of picking at random")
+	flags.BoolVar(&cmd.ipv6, "6", false, "map to IPv6 address instead of IPv4")
+	flags.Func("p", "allocate IPs from `cidr` within 127.0.0.0/8 or fc00::/7",
+		func(s string) error {
+			pool, err := lib127.ParsePool(s)
+			if err != nil {
+				return errors.New("not a CIDR within 127.0.0.0/8 or fc00::/7")
+			}
+			cmd.pools = append(cmd.pools, pool)
+			return nil
+		})
+}
+
 func (a App) exec(cmd command) int {
 	if cmd.printVersion {
 		fmt.Fprintf(a.writer(), "%s %s %s/%s\n",
@@ -107,48 +409,269 @@ func (a App) exec(cmd command) int {
 		return StatusSuccess
 	}
 
-	hosts, err := lib127.Open(cmd.filename)
+	if cmd.list {
+		return a.list(cmd)
+	}
+	if cmd.dns {
+		return a.serveDNS(cmd)
+	}
+	if cmd.serve {
+		return a.serveHTTP(cmd)
+	}
+	if cmd.backups {
+		return a.listBackups(cmd)
+	}
+	if cmd.undo {
+		return a.undo(cmd)
+	}
+	if cmd.doctor {
+		return a.doctor(cmd)
+	}
+	if cmd.run {
+		return a.runCommand(cmd)
+	}
+	if cmd.gc {
+		return a.gc(cmd)
+	}
+	if cmd.profile {
+		return a.profile(cmd)
+	}
+
+	hosts, err := lib127.Open(cmd.filename, a.options(cmd)...)
 	if err != nil {
 		return a.error(cmd, err)
 	}
+	defer func() { _ = hosts.Close() }()
+	a.warn(hosts)
+
+	if cmd.stdin {
+		hostnames, err := readLines(a.reader())
+		if err != nil {
+			return a.error(cmd, err)
+		}
+		cmd.hostnames = append(cmd.hostnames, hostnames...)
+	}
+
+	if len(cmd.hostnames) == 0 && !cmd.stdin {
+		ip, err := hosts.RandomIP()
+		if err != nil {
+			return a.error(cmd, err)
+		}
+		fmt.Fprintln(a.writer(), ip)
+		return StatusSuccess
+	}
+
+	// Print pairs of IPs and hostnames, unless given a single hostname.
+	pairs := len(cmd.hostnames) > 1 || cmd.stdin
+
+	status := StatusSuccess
+	var lines []string
+	for _, hostname := range cmd.hostnames {
+		cmd.hostname = hostname
+
+		ips, err := a.mapHostname(hosts, cmd, hostname)
+		if err != nil {
+			status = a.error(cmd, err)
+			continue
+		}
+
+		if cmd.echo {
+			lines = append(lines, hostname)
+			continue
+		}
+		for _, ip := range ips {
+			if pairs {
+				lines = append(lines, ip+" "+hostname)
+			} else {
+				lines = append(lines, ip)
+			}
+		}
+	}
+
+	if cmd.dryRun {
+		return a.printDiff(hosts, status)
+	}
+
+	if err := hosts.Save(); err != nil {
+		return a.error(cmd, err)
+	}
+
+	for _, line := range lines {
+		fmt.Fprintln(a.writer(), line)
+	}
+
+	return status
+}
+
+// options returns the options of lib127 for mapping hostnames as requested by
+// cmd.
+func (a App) options(cmd command) []lib127.Option {
+	var allocator lib127.Allocator = lib127.RandomAllocator{}
+	if cmd.hashed {
+		allocator = lib127.HashAllocator{}
+	}
 
-	var host string
+	opts := []lib127.Option{
+		lib127.WithForce(cmd.force), lib127.WithAllocator(allocator),
+		lib127.WithLockTimeout(cmd.lockTimeout),
+		lib127.WithBackups(cmd.backupDir, lib127.DefaultBackups),
+		lib127.WithTTL(cmd.ttl), lib127.WithProfile(cmd.profileName),
+	}
+	created := a.now()
+	opts = append(opts, lib127.WithMeta(lib127.Meta{
+		User: a.user(), Created: &created, Tag: cmd.tag, Note: cmd.note,
+	}))
 	switch {
-	case cmd.hostname == "":
-		host, err = hosts.RandomIP()
+	case cmd.dualStack:
+		opts = append(opts, lib127.WithFamily(lib127.DualStack))
+	case cmd.ipv6:
+		opts = append(opts, lib127.WithFamily(lib127.IPv6))
+	}
+	for _, pool := range cmd.pools {
+		opts = append(opts, lib127.WithPool(pool))
+	}
+	return opts
+}
+
+// currentUser returns the name of the user running the command, or of the user
+// that ran it with sudo. Returns an empty string if the user is unknown.
+func currentUser() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
+
+// printDiff prints the pending changes of a dry run. Returns StatusChanged if
+// there are any, unless status is a failure.
+func (a App) printDiff(hosts *lib127.Hosts, status int) int {
+	d := hosts.Diff()
+	fmt.Fprint(a.writer(), d)
+
+	if d != "" && status == StatusSuccess {
+		return StatusChanged
+	}
+	return status
+}
+
+// mapHostname maps or unmaps hostname as requested by cmd, and returns the IPs
+// to print. Dual-stack mappings yield an address of each IP version.
+func (a App) mapHostname(hosts *lib127.Hosts, cmd command, hostname string) ([]string, error) {
+	var ip string
+	var err error
+	switch {
+	case lib127.IsWildcard(hostname) && cmd.unmap:
+		ip, err = hosts.UnmapWildcard(hostname)
+	case lib127.IsWildcard(hostname) && cmd.alias == "" && cmd.ip == "":
+		ip, err = hosts.MapWildcard(hostname)
+	case cmd.alias != "":
+		ip, err = hosts.Alias(cmd.alias, hostname)
+	case cmd.ip != "":
+		ip, err = a.mapTo(hosts, hostname, cmd.ip)
 	case cmd.unmap:
-		host, err = hosts.Unmap(cmd.hostname)
+		ip, err = hosts.Unmap(hostname)
 	default:
-		host, err = hosts.Map(cmd.hostname)
+		ip, err = hosts.Map(hostname)
 	}
 
+	// Echo IP addresses instead of failing with an error.
+	if errors.Is(err, lib127.ErrHostnameIsIP) {
+		return []string{hostname}, nil
+	}
 	if err != nil {
-		return a.error(cmd, err)
+		return nil, err
 	}
 
-	if err := hosts.Save(); err != nil {
-		return a.error(cmd, err)
+	if !cmd.dualStack || cmd.unmap || lib127.IsWildcard(hostname) {
+		return []string{ip}, nil
+	}
+	ips, err := hosts.IPs(hostname)
+	if err != nil {
+		return nil, err
+	}
+	return ips, nil
+}
+
+// mapTo maps hostname to ip, and reports the replaced mappings.
+func (a App) mapTo(hosts *lib127.Hosts, hostname, ip string) (string, error) {
+	replaced, err := hosts.MapTo(hostname, ip)
+	if err != nil {
+		return "", err
+	}
+
+	for _, m := range replaced {
+		fmt.Fprintf(a.errorWriter(), "%s: replaced mapping: %s %s\n",
+			a.name(), m.IP, strings.Join(m.Hostnames, " "))
 	}
+	return ip, nil
+}
 
-	if cmd.echo {
-		host = cmd.hostname
+// readLines reads non-empty lines from r, trimming surrounding space.
+func readLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
 	}
-	fmt.Fprintln(a.writer(), host)
 
-	return StatusSuccess
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read stdin: %w", err)
+	}
+	return lines, nil
+}
+
+// warn reports the malformed lines of the hosts file, which are ignored.
+func (a App) warn(hosts *lib127.Hosts) {
+	for _, w := range hosts.Warnings() {
+		a.parseError("warning: ", w)
+	}
+}
+
+func (a App) parseError(prefix string, err *lib127.ParseError) {
+	fmt.Fprintf(a.errorWriter(), "%s: %s%s:%d:%d: %s: %q\n",
+		a.name(), prefix, err.File, err.Line, err.Column, err.Reason, err.Text)
 }
 
 func (a App) error(cmd command, err error) int {
 	var pathErr *fs.PathError
+	var parseErr *lib127.ParseError
 	switch {
-	case errors.Is(err, lib127.ErrHostnameIsIP):
-		// Echo IP addresses to stdout instead of failing with an error.
-		fmt.Fprintln(a.writer(), cmd.hostname)
-		return StatusSuccess
 	case errors.Is(err, lib127.ErrHostnameInvalid):
 		fmt.Fprintf(a.errorWriter(), "%s: invalid hostname: %s\n", a.name(), cmd.hostname)
 	case errors.Is(err, lib127.ErrCannotUnmapLocalhost):
 		fmt.Fprintf(a.errorWriter(), "%s: cannot remove localhost\n", a.name())
+	case errors.Is(err, lib127.ErrCannotRemapLocalhost):
+		fmt.Fprintf(a.errorWriter(), "%s: cannot remap localhost\n", a.name())
+	case errors.Is(err, lib127.ErrNotManaged) && cmd.alias != "":
+		fmt.Fprintf(a.errorWriter(), "%s: hostnames not managed by %s (use -F to force): %s %s\n",
+			a.name(), a.name(), cmd.alias, cmd.hostname)
+	case errors.Is(err, lib127.ErrNotManaged):
+		fmt.Fprintf(a.errorWriter(), "%s: hostname not managed by %s (use -F to force): %s\n",
+			a.name(), a.name(), cmd.hostname)
+	case errors.Is(err, lib127.ErrNoFreeAddress):
+		fmt.Fprintf(a.errorWriter(), "%s: no free address in pool\n", a.name())
+	case errors.Is(err, lib127.ErrNotMapped):
+		fmt.Fprintf(a.errorWriter(), "%s: hostname not mapped: %s\n", a.name(), cmd.alias)
+	case errors.Is(err, lib127.ErrInvalidIP):
+		fmt.Fprintf(a.errorWriter(), "%s: invalid loopback address: %s\n", a.name(), cmd.ip)
+	case errors.Is(err, lib127.ErrIPInUse):
+		fmt.Fprintf(a.errorWriter(), "%s: IP in use by another hostname (use -F to force): %s\n",
+			a.name(), cmd.ip)
+	case errors.Is(err, lib127.ErrBackupNotFound):
+		fmt.Fprintf(a.errorWriter(), "%s: backup not found: %s\n", a.name(), cmd.backup)
+	case errors.Is(err, dns.ErrNotLoopback):
+		fmt.Fprintf(a.errorWriter(), "%s: not a loopback address: %s\n", a.name(), cmd.listen)
+	case errors.Is(err, lib127.ErrLocked):
+		fmt.Fprintf(a.errorWriter(), "%s: hosts file is locked by another process: %s\n",
+			a.name(), cmd.filename)
+	case errors.As(err, &parseErr):
+		a.parseError("", parseErr)
 	case errors.As(err, &pathErr):
 		fmt.Fprintf(a.errorWriter(), "%s: %v\n", a.name(), pathErr)
 	default:
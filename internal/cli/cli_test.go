@@ -2,13 +2,16 @@ package cli_test
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/lende/127/internal/cli"
 	"github.com/lende/127/internal/testdata"
+	"github.com/lende/127/lib127"
 )
 
 func TestApp(t *testing.T) {
@@ -21,22 +24,424 @@ func TestApp(t *testing.T) {
 	run("-f", hostsPath, "-u", "localhost").assertStderr(t, "127t: cannot remove localhost")
 	run("-f", hostsPath, "127.205.131.186").assertStdout(t, `127.205.131.186`)
 	run("-f", hostsPath, "foo/bar").assertStderr(t, `127t: invalid hostname: foo/bar`)
+	run("-f", hostsPath, "-u", "unmanaged.test").
+		assertStderr(t, `127t: hostname not managed by 127t (use -F to force): unmanaged.test`)
+	run("-f", hostsPath, "-u", "-F", "unmanaged.test").assertStdout(t, "127.0.0.4")
+	run("-f", hostsPath, "-p", "127.42.0.0/31", "-d", "pool1.test").assertStdout(t, "127.42.0.1")
+	run("-f", hostsPath, "-p", "127.42.0.0/31", "-d", "pool2.test").assertStdout(t, "127.42.0.0")
+	run("-f", hostsPath, "-p", "127.42.0.0/31", "pool3.test").
+		assertStderr(t, "127t: no free address in pool")
+	run("-f", hostsPath, "-d", "hashed.test").assertStdout(t, "127.104.254.134")
 
 	missingFile := filepath.Join(t.TempDir(), "hosts")
 	run("-f", missingFile).
 		assertStderr(t, `127t: open %s: no such file or directory`, missingFile)
 }
 
+func TestMultipleHostnames(t *testing.T) {
+	t.Parallel()
+
+	hostsPath := testdata.HostsFile(t)
+	run("-f", hostsPath, "loopback.test", "localhost", "127.0.0.9").assertStdout(t,
+		"127.0.0.3 loopback.test\n127.0.0.1 localhost\n127.0.0.9 127.0.0.9")
+	runWithInput("loopback.test\n\n  localhost  \n", "-f", hostsPath, "-i").assertStdout(t,
+		"127.0.0.3 loopback.test\n127.0.0.1 localhost")
+	run("-f", hostsPath, "-e", "loopback.test", "localhost").assertStdout(t,
+		"loopback.test\nlocalhost")
+
+	// Valid hostnames are processed even if others fail.
+	o := run("-f", hostsPath, "-u", "loopback.test", "foo/bar")
+	o.assert(t, cli.StatusFailure, "127.0.0.3 loopback.test", "127t: invalid hostname: foo/bar")
+	run("-f", hostsPath, "-i").assertStdout(t, "")
+	run("-f", hostsPath, "-u", "loopback.test").assertStdout(t, "")
+}
+
+func TestIPv6(t *testing.T) {
+	t.Parallel()
+
+	hostsPath := testdata.HostsFile(t)
+	run("-f", hostsPath, "-6", "v6.test").assertStdout(t, "::1")
+	run("-f", hostsPath, "-6", "-d", "-p", "fd7f::/127", "pool.test").assertStdout(t, "fd7f::")
+	run("-f", hostsPath, "-b", "-d", "-p", "127.42.0.0/31", "-p", "fd7f::/127", "dual.test").
+		assertStdout(t, "127.42.0.1\nfd7f::1")
+	run("-f", hostsPath, "-b", "-d", "-p", "127.42.0.0/31", "-p", "fd7f::/127", "dual.test",
+		"v6.test").assertStdout(t,
+		"127.42.0.1 dual.test\nfd7f::1 dual.test\n::1 v6.test\n127.42.0.0 v6.test")
+	run("-f", hostsPath, "dual.test").assertStdout(t, "127.42.0.1")
+
+	// Hostnames mapped to a single address get the missing one added.
+	run("-f", hostsPath, "-b", "loopback.test").assertStdout(t, "127.0.0.3\n::1")
+}
+
+func TestMapTo(t *testing.T) {
+	t.Parallel()
+
+	hostsPath := testdata.HostsFile(t)
+	run("-f", hostsPath, "-t", "127.0.0.5", "new.test").assertStdout(t, "127.0.0.5")
+	run("-f", hostsPath, "-t", "127.0.0.5", "localhost").
+		assertStderr(t, "127t: cannot remap localhost")
+	run("-f", hostsPath, "-t", "127.0.0.6", "new.test").assert(t, cli.StatusSuccess,
+		"127.0.0.6", "127t: replaced mapping: 127.0.0.5 new.test")
+	run("-f", hostsPath, "-t", "127.0.0.3", "other.test").
+		assertStderr(t, "127t: IP in use by another hostname (use -F to force): 127.0.0.3")
+	run("-f", hostsPath, "-F", "-t", "127.0.0.3", "other.test").assert(t, cli.StatusSuccess,
+		"127.0.0.3", "127t: replaced mapping: 127.0.0.3 loopback.test")
+	run("-f", hostsPath, "-t", "192.0.2.1", "other.test").
+		assertStderr(t, "127t: invalid loopback address: 192.0.2.1")
+	run("-f", hostsPath, "-t", "127.0.0.7", "a.test", "b.test").
+		assertStderr(t, "127t: -t requires a single hostname to map")
+	run("-f", hostsPath, "-u", "loopback.test").assertStdout(t, "")
+	run("-f", hostsPath, "other.test").assertStdout(t, "127.0.0.3")
+}
+
+func TestAlias(t *testing.T) {
+	t.Parallel()
+
+	hostsPath := testdata.HostsFile(t)
+	run("-f", hostsPath, "-A", "loopback.test", "api.test", "www.test").
+		assertStdout(t, "127.0.0.3 api.test\n127.0.0.3 www.test")
+	run("-f", hostsPath, "-A", "loopback.test", "localhost").
+		assertStderr(t, "127t: cannot remap localhost")
+	run("-f", hostsPath, "-u", "loopback.test").assertStdout(t, "127.0.0.3")
+	run("-f", hostsPath, "www.test").assertStdout(t, "127.0.0.3")
+	run("list", "-f", hostsPath, "-o", "plain").assertStdout(t, `127.0.0.1 localhost
+127.0.0.1 localhost.localdomain
+127.0.0.4 unmanaged.test
+127.0.0.3 api.test
+127.0.0.3 www.test`)
+	run("-f", hostsPath, "-A", "missing.test", "alias.test").
+		assertStderr(t, "127t: hostname not mapped: missing.test")
+	run("-f", hostsPath, "-A", "unmanaged.test", "alias.test").assertStderr(t,
+		"127t: hostnames not managed by 127t (use -F to force): unmanaged.test alias.test")
+	run("-f", hostsPath, "-A", "www.test", "-u", "alias.test").
+		assertStderr(t, "127t: -A can not be combined with -t or -u")
+}
+
+func TestWildcard(t *testing.T) {
+	t.Parallel()
+
+	hostsPath := testdata.HostsFile(t)
+	run("-f", hostsPath, "*.loopback.test").assertStdout(t, "127.0.0.3")
+	run("list", "-f", hostsPath).assertStdout(t, `IP         HOSTNAMES                        METADATA
+127.0.0.1  localhost localhost.localdomain
+127.0.0.4  unmanaged.test
+127.0.0.3  loopback.test
+127.0.0.3  *.loopback.test`)
+	run("-f", hostsPath, "-u", "*.loopback.test").assertStdout(t, "127.0.0.3")
+	run("-f", hostsPath, "-u", "*.loopback.test").assertStdout(t, "")
+	run("-f", hostsPath, "*.foo/bar").assertStderr(t, "127t: invalid hostname: *.foo/bar")
+	run("dns", "-f", hostsPath, "-l", "192.0.2.1:53").
+		assertStderr(t, "127t: not a loopback address: 192.0.2.1:53")
+}
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+
+	hostsPath := testdata.HostsFile(t)
+	run("serve", "-f", hostsPath, "-l", "0.0.0.0:8127").
+		assertStderr(t, "127t: not a loopback address: 0.0.0.0:8127")
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("\n"), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	run("serve", "-f", hostsPath, "-k", tokenPath).
+		assertStderr(t, "127t: empty token file: %s", tokenPath)
+}
+
+func TestDryRun(t *testing.T) {
+	t.Parallel()
+
+	hostsPath := testdata.HostsFile(t)
+	run("-f", hostsPath, "-n", "-t", "127.0.0.5", "new.test").assert(t, cli.StatusChanged,
+		fmt.Sprintf(`--- %[1]s
++++ %[1]s
+@@ -8,4 +8,5 @@
+ 
+ # BEGIN 127 MANAGED BLOCK
+ 127.0.0.3 loopback.test
++127.0.0.5 new.test # 127: created=2026-01-02T03:04:05Z user=tester
+ # END 127 MANAGED BLOCK`, hostsPath), "")
+	run("-f", hostsPath, "-n", "loopback.test").assertStdout(t, "")
+	run("-f", hostsPath, "-u", "new.test").assertStdout(t, "")
+	run("-f", hostsPath, "-n", "-u", "unmanaged.test").assertStderr(t,
+		"127t: hostname not managed by 127t (use -F to force): unmanaged.test")
+}
+
+func TestUndo(t *testing.T) {
+	t.Parallel()
+
+	hostsPath := testdata.HostsFile(t)
+	run("undo", "-f", hostsPath).assertStderr(t, "127t: no backups of %s", hostsPath)
+	run("backups", "-f", hostsPath).assertStdout(t, "")
+
+	run("-f", hostsPath, "-t", "127.0.0.5", "new.test").assertStdout(t, "127.0.0.5")
+	out := run("backups", "-f", hostsPath)
+	backup := strings.TrimSpace(out.stdout)
+	if !strings.HasPrefix(backup, "hosts-") || strings.Contains(backup, "\n") {
+		t.Fatalf("want a single backup, got: %q", out.stdout)
+	}
+
+	run("undo", "-f", hostsPath, "-n").assert(t, cli.StatusChanged, fmt.Sprintf(`--- %[1]s
++++ %[1]s
+@@ -8,5 +8,4 @@
+ 
+ # BEGIN 127 MANAGED BLOCK
+ 127.0.0.3 loopback.test
+-127.0.0.5 new.test # 127: created=2026-01-02T03:04:05Z user=tester
+ # END 127 MANAGED BLOCK`, hostsPath), "")
+	run("undo", "-f", hostsPath).assertStdout(t, backup)
+	run("-f", hostsPath, "-u", "new.test").assertStdout(t, "")
+	run("undo", "-f", hostsPath, "missing").assertStderr(t, "127t: backup not found: missing")
+}
+
+func TestParseError(t *testing.T) {
+	t.Parallel()
+
+	hostsPath := testdata.Fixture(t, "malformed.hosts")
+	run("-f", hostsPath, "loopback.test").assert(t, cli.StatusSuccess, "127.0.0.3",
+		fmt.Sprintf(`127t: warning: %[1]s:2:1: invalid IP address: "localhost"
+127t: warning: %[1]s:3:1: missing hostname: "127.0.0.2"
+127t: warning: %[1]s:4:3: invalid IP address: "300.1.2.3"
+127t: warning: %[1]s:8:1: invalid IP address: "127.0.0.3.4"`, hostsPath))
+
+	if err := os.WriteFile(hostsPath, []byte("# BEGIN 127 MANAGED BLOCK\n"), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	run("list", "-f", hostsPath).assertStderr(t,
+		`127t: %s:1:1: managed block is never ended: "# BEGIN 127 MANAGED BLOCK"`, hostsPath)
+}
+
+func TestGC(t *testing.T) {
+	t.Parallel()
+
+	hostsPath := filepath.Join(t.TempDir(), "hosts")
+	content := "# BEGIN 127 MANAGED BLOCK\n" +
+		"127.0.0.5 expired.test # 127: expires=2000-01-01T00:00:00Z\n" +
+		"127.0.0.7 pending.test # 127: expires=2026-01-02T03:04:06Z\n" +
+		"# END 127 MANAGED BLOCK\n"
+	if err := os.WriteFile(hostsPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	run("-f", hostsPath, "-T", "1h", "-t", "127.0.0.6", "later.test").assertStdout(t, "127.0.0.6")
+	assertHostsFile(t, hostsPath,
+		"127.0.0.6 later.test # 127: created=2026-01-02T03:04:05Z expires=", "later.test\n")
+
+	out := run("gc", "-f", hostsPath, "-n")
+	if out.status != cli.StatusChanged || strings.Count(out.stdout, "\n-") != 1 ||
+		!strings.Contains(out.stdout, "\n-127.0.0.5 expired.test") {
+		t.Errorf("Want diff removing expired.test, got: %q", out.stdout)
+	}
+	run("gc", "-f", hostsPath).assertStdout(t, "127.0.0.5 expired.test")
+	run("gc", "-f", hostsPath).assertStdout(t, "")
+	assertHostsFile(t, hostsPath, "later.test", "expired.test")
+
+	// Mappings expire by the clock of the application.
+	assertHostsFile(t, hostsPath, "pending.test", "expired.test")
+}
+
+func TestProfile(t *testing.T) {
+	t.Parallel()
+
+	hostsPath := testdata.HostsFile(t)
+	run("-f", hostsPath, "-P", "shop", "-t", "127.0.0.5", "shop.test").assertStdout(t, "127.0.0.5")
+	run("-f", hostsPath, "-P", "blog", "-t", "127.0.0.6", "blog.test").assertStdout(t, "127.0.0.6")
+	run("profile", "-f", hostsPath).assertStdout(t, "blog\nshop")
+
+	run("profile", "-f", hostsPath, "-n", "off", "shop").assert(t, cli.StatusChanged,
+		fmt.Sprintf(`--- %[1]s
++++ %[1]s
+@@ -8,6 +8,6 @@
+ 
+ # BEGIN 127 MANAGED BLOCK
+ 127.0.0.3 loopback.test
+-127.0.0.5 shop.test # 127: created=2026-01-02T03:04:05Z profile=shop user=tester
++# 127.0.0.5 shop.test # 127: created=2026-01-02T03:04:05Z profile=shop user=tester
+ 127.0.0.6 blog.test # 127: created=2026-01-02T03:04:05Z profile=blog user=tester
+ # END 127 MANAGED BLOCK`, hostsPath), "")
+	run("profile", "-f", hostsPath, "off", "shop").assertStdout(t, "127.0.0.5 shop.test")
+	run("profile", "-f", hostsPath, "list", "shop").assertStdout(t, "127.0.0.5 shop.test (off)")
+	run("profile", "-f", hostsPath, "export", "shop").
+		assertStdout(t, "# 127.0.0.5 shop.test # 127: created=2026-01-02T03:04:05Z "+
+			"profile=shop user=tester")
+	run("profile", "-f", hostsPath, "on", "shop").assertStdout(t, "127.0.0.5 shop.test")
+	run("profile", "-f", hostsPath, "unmap", "shop").assertStdout(t, "127.0.0.5 shop.test")
+	run("profile", "-f", hostsPath).assertStdout(t, "blog")
+	assertHostsFile(t, hostsPath, "blog.test", "shop.test")
+
+	run("profile", "-f", hostsPath, "drop", "blog").
+		assertStderr(t, "127t: profile takes an action and a profile name")
+}
+
+func TestDoctor(t *testing.T) {
+	t.Parallel()
+
+	run("doctor", "-f", testdata.HostsFile(t)).assertStdout(t, "")
+
+	hostsPath := testdata.Fixture(t, "problems.hosts")
+	run("doctor", "-f", hostsPath, "-x", "-n").assert(t, cli.StatusChanged, fmt.Sprintf(`--- %[1]s
++++ %[1]s
+@@ -5,8 +5,7 @@
+ 
+ # BEGIN 127 MANAGED BLOCK
+ 127.0.0.5 db.test
+-127.0.0.5 db.test
+-127.0.0.6 db.test api.test
++127.0.0.6 api.test
+ 127.0.0.7 Upper.Test
+ 127.0.0.8 bad_name.test
+ # END 127 MANAGED BLOCK`, hostsPath), "")
+
+	out := run("doctor", "-f", hostsPath, "-x")
+	out.assert(t, cli.StatusFailure, fmt.Sprintf(
+		`%[1]s:8: fixed: db.test mapped to 127.0.0.5 again, first on line 7
+%[1]s:9: fixed: db.test mapped to 127.0.0.6, but to 127.0.0.5 on line 7 first
+%[1]s:2: error: localhost mapped to non-loopback address 192.168.1.10
+%[1]s:2: error: localhost mapped to 192.168.1.10, but to 127.0.0.1 on line 1 first
+%[1]s:4: warning: 127.0.0.4 is also assigned on line 3
+%[1]s:10: warning: Upper.Test is looked up as upper.test
+%[1]s:11: error: invalid hostname: adapt "bad_name.test": idna: disallowed rune U+005F
+%[1]s:13: error: missing hostname: "127.0.0.9"`,
+		hostsPath), "")
+	run("doctor", "-f", hostsPath, "unexpected").
+		assert(t, cli.StatusFailure, "", "127t: doctor takes no arguments")
+}
+
+func TestExec(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+
+	hostsPath := testdata.HostsFile(t)
+	script := `echo $IP_SVC_TEST $IP_LOOPBACK_TEST; grep -c svc.test "$0"; exit 3`
+	run("exec", "-f", hostsPath, "-d", "-n", "svc.test", "-n", "loopback.test",
+		"--", "sh", "-c", script, hostsPath).assert(t, 3, "127.110.160.131 127.0.0.3\n1", "")
+
+	// Only the hostnames mapped by exec are unmapped.
+	assertHostsFile(t, hostsPath, "loopback.test", "svc.test")
+
+	// A command killed by a signal exits with 128 plus the signal, like in a shell.
+	run("exec", "-f", hostsPath, "-n", "svc.test", "--", "sh", "-c", "kill -TERM $$").
+		assert(t, 143, "", "")
+	assertHostsFile(t, hostsPath, "loopback.test", "svc.test")
+
+	run("exec", "-f", hostsPath, "-n", "svc.test", "--", "missing-command-127").
+		assertStderr(t, `127t: exec: "missing-command-127": executable file not found in $PATH`)
+	assertHostsFile(t, hostsPath, "loopback.test", "svc.test")
+
+	run("exec", "-f", hostsPath, "true").
+		assertStderr(t, "127t: exec requires hostnames to map and a command to run")
+}
+
+func TestLocked(t *testing.T) {
+	t.Parallel()
+
+	hostsPath := testdata.HostsFile(t)
+	hosts, err := lib127.Open(hostsPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer func() { _ = hosts.Close() }()
+
+	run("-f", hostsPath, "-w", "0", "locked.test").
+		assertStderr(t, "127t: hosts file is locked by another process: %s", hostsPath)
+}
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	hostsPath := testdata.HostsFile(t)
+	run("list", "-f", hostsPath).assertStdout(t, `IP         HOSTNAMES                        METADATA
+127.0.0.1  localhost localhost.localdomain
+127.0.0.4  unmanaged.test
+127.0.0.3  loopback.test`)
+	run("list", "-f", hostsPath, "-o", "plain").assertStdout(t, `127.0.0.1 localhost
+127.0.0.1 localhost.localdomain
+127.0.0.4 unmanaged.test
+127.0.0.3 loopback.test`)
+	run("list", "-f", hostsPath, "-a", "-o", "plain").assertStdout(t, `127.0.0.1 localhost
+127.0.0.1 localhost.localdomain
+127.0.0.4 unmanaged.test
+93.184.216.34 example.com
+127.0.0.3 loopback.test`)
+	run("list", "-f", hostsPath, "-o", "json").assertStdout(t, `[
+  {
+    "ip": "127.0.0.1",
+    "hostnames": [
+      "localhost",
+      "localhost.localdomain"
+    ],
+    "line": 1,
+    "managed": false
+  },
+  {
+    "ip": "127.0.0.4",
+    "hostnames": [
+      "unmanaged.test"
+    ],
+    "line": 2,
+    "managed": false
+  },
+  {
+    "ip": "127.0.0.3",
+    "hostnames": [
+      "loopback.test"
+    ],
+    "line": 10,
+    "managed": true
+  }
+]`)
+	run("list", "-f", hostsPath, "-o", "xml").assertStderr(t, "127t: invalid output format: xml")
+
+	run("-f", hostsPath, "-g", "shop", "-m", "for tests", "-t", "127.0.0.5", "shop.test").
+		assertStdout(t, "127.0.0.5")
+	run("list", "-f", hostsPath, "-g", "shop", "-o", "plain").assertStdout(t, "127.0.0.5 shop.test "+
+		`user=tester created=2026-01-02T03:04:05Z tag=shop note="for tests"`)
+	run("list", "-f", hostsPath, "-g", "other").assertStdout(t, "IP  HOSTNAMES  METADATA")
+	assertHostsFile(t, hostsPath, `note="for tests" tag=shop`, "tag=other")
+
+	run("-f", hostsPath, "-t", "127.0.0.6", "untagged.test").assertStdout(t, "127.0.0.6")
+	assertHostsFile(t, hostsPath,
+		"127.0.0.6 untagged.test # 127: created=2026-01-02T03:04:05Z user=tester\n", "tag=other")
+	run("list", "-f", hostsPath).assertStdout(t, `IP         HOSTNAMES                        METADATA
+127.0.0.1  localhost localhost.localdomain
+127.0.0.4  unmanaged.test
+127.0.0.3  loopback.test
+127.0.0.5  shop.test                        %[1]s tag=shop note="for tests"
+127.0.0.6  untagged.test                    %[1]s`, "user=tester created=2026-01-02T03:04:05Z")
+}
+
 type output struct {
 	status         int
 	stdout, stderr string
 }
 
 func run(args ...string) output {
+	return runWithInput("", args...)
+}
+
+// assertHostsFile asserts that the hosts file contains want, but not unwanted.
+func assertHostsFile(t *testing.T, path, want, unwanted string) {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(string(b), want) || strings.Contains(string(b), unwanted) {
+		t.Errorf("Want hosts file with %s, but not %s, got: %q", want, unwanted, b)
+	}
+}
+
+func runWithInput(stdin string, args ...string) output {
 	var stdout, stderr strings.Builder
 	app := cli.App{
 		Name: "127t", Version: "0.0.0-test",
+		Reader: strings.NewReader(stdin),
 		Writer: &stdout, ErrorWriter: &stderr,
+		User: "tester", Now: func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) },
 	}
 
 	return output{
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lende/127/lib127"
+	"github.com/lende/127/lib127/dns"
+)
+
+func (a App) serveDNS(cmd command) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := dns.Server{
+		Addr:     cmd.listen,
+		Resolver: dns.HostsFile(cmd.filename, lib127.WithLockTimeout(cmd.lockTimeout)),
+	}
+	if err := s.ListenAndServe(ctx); err != nil {
+		return a.error(cmd, err)
+	}
+	return StatusSuccess
+}
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/lende/127/lib127"
+)
+
+// doctor prints the problems found in the hosts file, one per line. With -x,
+// fixable problems are fixed first, and printed as fixed. Returns StatusFailure
+// if any problems remain.
+func (a App) doctor(cmd command) int {
+	hosts, err := lib127.Open(cmd.filename, lib127.WithLockTimeout(cmd.lockTimeout),
+		lib127.WithBackups(cmd.backupDir, lib127.DefaultBackups))
+	if err != nil {
+		return a.error(cmd, err)
+	}
+	defer func() { _ = hosts.Close() }()
+
+	if cmd.fix {
+		fixed, err := hosts.Fix()
+		if err != nil {
+			return a.error(cmd, err)
+		}
+		if cmd.dryRun {
+			return a.printDiff(hosts, StatusSuccess)
+		}
+		if err := hosts.Save(); err != nil {
+			return a.error(cmd, err)
+		}
+		for _, p := range fixed {
+			fmt.Fprintf(a.writer(), "%s:%d: fixed: %s\n", cmd.filename, p.Line, p.Message)
+		}
+	}
+
+	// Line numbers refer to the file as it was read, before any fixes.
+	problems := hosts.Check()
+	for _, p := range problems {
+		hint := ""
+		if p.Fixable {
+			hint = " (fix with -x)"
+		}
+		fmt.Fprintf(a.writer(), "%s:%d: %s: %s%s\n",
+			cmd.filename, p.Line, p.Severity, p.Message, hint)
+	}
+
+	if len(problems) > 0 {
+		return StatusFailure
+	}
+	return StatusSuccess
+}
@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lende/127/lib127"
+)
+
+// gc removes expired mappings, and prints them.
+func (a App) gc(cmd command) int {
+	hosts, err := lib127.Open(cmd.filename, lib127.WithLockTimeout(cmd.lockTimeout),
+		lib127.WithBackups(cmd.backupDir, lib127.DefaultBackups))
+	if err != nil {
+		return a.error(cmd, err)
+	}
+	defer func() { _ = hosts.Close() }()
+	a.warn(hosts)
+
+	expired := hosts.Sweep(a.now())
+	if cmd.dryRun {
+		return a.printDiff(hosts, StatusSuccess)
+	}
+	if err := hosts.Save(); err != nil {
+		return a.error(cmd, err)
+	}
+
+	for _, m := range expired {
+		fmt.Fprintln(a.writer(), m.IP, strings.Join(m.Hostnames, " "))
+	}
+	return StatusSuccess
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lende/127/lib127"
+)
+
+// Output formats supported by the list command.
+const (
+	formatTable = "table"
+	formatPlain = "plain"
+	formatJSON  = "json"
+)
+
+func isFormat(format string) bool {
+	switch format {
+	case formatTable, formatPlain, formatJSON:
+		return true
+	}
+	return false
+}
+
+func (a App) list(cmd command) int {
+	hosts, err := lib127.Open(cmd.filename, lib127.WithLockTimeout(cmd.lockTimeout))
+	if err != nil {
+		return a.error(cmd, err)
+	}
+	defer func() { _ = hosts.Close() }()
+	a.warn(hosts)
+
+	scope := lib127.ScopeLoopback
+	if cmd.all {
+		scope = lib127.ScopeAll
+	}
+	mappings := append(hosts.Mappings(scope), hosts.Wildcards(scope)...)
+	if cmd.tag != "" {
+		mappings = slices.DeleteFunc(mappings, func(m lib127.Mapping) bool {
+			return m.Tag != cmd.tag
+		})
+	}
+
+	switch cmd.format {
+	case formatPlain:
+		err = printPlain(a.writer(), mappings)
+	case formatJSON:
+		err = printJSON(a.writer(), mappings)
+	default:
+		err = printTable(a.writer(), mappings)
+	}
+
+	if err != nil {
+		return a.error(cmd, err)
+	}
+	return StatusSuccess
+}
+
+// printTable prints mappings as column-aligned text with a header.
+func printTable(w io.Writer, mappings []lib127.Mapping) error {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IP\tHOSTNAMES\tMETADATA")
+	for _, m := range mappings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", m.IP, strings.Join(m.Hostnames, " "), describe(m))
+	}
+	_ = tw.Flush()
+
+	// Mappings without metadata leave the padding of their last column behind.
+	for _, line := range strings.SplitAfter(b.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " \n")); err != nil {
+			return fmt.Errorf("write table: %w", err)
+		}
+	}
+	return nil
+}
+
+// printPlain prints each hostname and its IP on a separate line, followed by
+// the metadata of the mapping, if any.
+func printPlain(w io.Writer, mappings []lib127.Mapping) error {
+	for _, m := range mappings {
+		for _, hostname := range m.Hostnames {
+			fields := []string{m.IP, hostname}
+			if meta := describe(m); meta != "" {
+				fields = append(fields, meta)
+			}
+			if _, err := fmt.Fprintln(w, strings.Join(fields, " ")); err != nil {
+				return fmt.Errorf("write plain: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// describe returns the metadata of m as space-separated key=value pairs. Values
+// that contain spaces or quotes are quoted.
+func describe(m lib127.Mapping) string {
+	var pairs []string
+	add := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if strings.ContainsAny(value, " \t\"") {
+			value = strconv.Quote(value)
+		}
+		pairs = append(pairs, key+"="+value)
+	}
+
+	add("user", m.User)
+	add("created", formatTime(m.Created))
+	add("tag", m.Tag)
+	add("note", m.Note)
+	add("expires", formatTime(m.Expires))
+	add("profile", m.Profile)
+	return strings.Join(pairs, " ")
+}
+
+// formatTime returns t in RFC 3339 format, or an empty string if t is nil.
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// printJSON prints mappings as an indented JSON array.
+func printJSON(w io.Writer, mappings []lib127.Mapping) error {
+	if mappings == nil {
+		mappings = []lib127.Mapping{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(mappings); err != nil {
+		return fmt.Errorf("write json: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lende/127/lib127"
+)
+
+// Actions of the profile command.
+const (
+	profileList   = "list"
+	profileExport = "export"
+	profileOff    = "off"
+	profileOn     = "on"
+	profileUnmap  = "unmap"
+)
+
+func isProfileAction(action string) bool {
+	switch action {
+	case profileList, profileExport, profileOff, profileOn, profileUnmap:
+		return true
+	}
+	return false
+}
+
+// profile prints the names of the profiles, or performs the action of cmd on
+// the mappings of a profile.
+func (a App) profile(cmd command) int {
+	hosts, err := lib127.Open(cmd.filename, lib127.WithLockTimeout(cmd.lockTimeout),
+		lib127.WithBackups(cmd.backupDir, lib127.DefaultBackups))
+	if err != nil {
+		return a.error(cmd, err)
+	}
+	defer func() { _ = hosts.Close() }()
+	a.warn(hosts)
+
+	if len(cmd.hostnames) == 0 {
+		for _, name := range hosts.Profiles() {
+			fmt.Fprintln(a.writer(), name)
+		}
+		return StatusSuccess
+	}
+
+	action, name := cmd.hostnames[0], cmd.hostnames[1]
+	var mappings []lib127.Mapping
+	switch action {
+	case profileExport:
+		fmt.Fprint(a.writer(), hosts.ExportProfile(name))
+		return StatusSuccess
+	case profileList:
+		for _, m := range hosts.ProfileMappings(name) {
+			if m.Disabled {
+				fmt.Fprintln(a.writer(), m.IP, strings.Join(m.Hostnames, " "), "(off)")
+			} else {
+				fmt.Fprintln(a.writer(), m.IP, strings.Join(m.Hostnames, " "))
+			}
+		}
+		return StatusSuccess
+	case profileOff:
+		mappings = hosts.DisableProfile(name)
+	case profileOn:
+		mappings = hosts.EnableProfile(name)
+	case profileUnmap:
+		mappings = hosts.UnmapProfile(name)
+	}
+
+	if cmd.dryRun {
+		return a.printDiff(hosts, StatusSuccess)
+	}
+	if err := hosts.Save(); err != nil {
+		return a.error(cmd, err)
+	}
+
+	for _, m := range mappings {
+		fmt.Fprintln(a.writer(), m.IP, strings.Join(m.Hostnames, " "))
+	}
+	return StatusSuccess
+}
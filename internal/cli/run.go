@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/lende/127/lib127"
+)
+
+// runCommand maps the hostnames of cmd, and runs its command with their IPs in
+// the environment. Signals received meanwhile are forwarded to the command,
+// except for interrupts from a terminal, which reach it directly. The hostnames
+// that were not mapped before are unmapped once the command exits, whatever its
+// outcome. Returns the exit status of the command.
+func (a App) runCommand(cmd command) (status int) {
+	env, mapped, status := a.mapEnv(cmd)
+	defer func() {
+		if s := a.unmapAll(cmd, mapped); s != StatusSuccess {
+			status = s
+		}
+	}()
+	if status != StatusSuccess {
+		return status
+	}
+
+	c := exec.Command(cmd.command[0], cmd.command[1:]...)
+	c.Env = append(os.Environ(), env...)
+	c.Stdin, c.Stdout, c.Stderr = a.reader(), a.writer(), a.errorWriter()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	if err := c.Start(); err != nil {
+		return a.error(cmd, err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	interactive := isTerminal(a.reader())
+	go func() {
+		for {
+			select {
+			case s := <-signals:
+				// A terminal sends its interrupts to the command as well.
+				if !interactive || (s != os.Interrupt && s != syscall.SIGQUIT) {
+					_ = c.Process.Signal(s)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Like a shell, report a command killed by a signal as 128 plus the signal.
+	var exitErr *exec.ExitError
+	if err := c.Wait(); errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return exitErr.ExitCode()
+	} else if err != nil {
+		return a.error(cmd, err)
+	}
+	return StatusSuccess
+}
+
+// isTerminal reports whether r is a terminal.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// mapEnv maps the hostnames of cmd, and returns the environment variables that
+// hold their IPs, along with the hostnames that were not mapped before.
+func (a App) mapEnv(cmd command) (env, mapped []string, status int) {
+	hosts, err := lib127.Open(cmd.filename, a.options(cmd)...)
+	if err != nil {
+		return nil, nil, a.error(cmd, err)
+	}
+	defer func() { _ = hosts.Close() }()
+	a.warn(hosts)
+
+	for _, hostname := range cmd.hostnames {
+		cmd.hostname = hostname
+
+		ip, err := hosts.IP(hostname)
+		if err == nil && ip == "" {
+			mapped = append(mapped, hostname)
+			ip, err = hosts.Map(hostname)
+		}
+		if err != nil {
+			return nil, nil, a.error(cmd, err)
+		}
+		env = append(env, envName(hostname)+"="+ip)
+	}
+
+	if err := hosts.Save(); err != nil {
+		return nil, nil, a.error(cmd, err)
+	}
+	return env, mapped, StatusSuccess
+}
+
+// unmapAll unmaps the given hostnames, which were mapped by mapEnv.
+func (a App) unmapAll(cmd command, hostnames []string) int {
+	if len(hostnames) == 0 {
+		return StatusSuccess
+	}
+
+	hosts, err := lib127.Open(cmd.filename, a.options(cmd)...)
+	if err != nil {
+		return a.error(cmd, err)
+	}
+	defer func() { _ = hosts.Close() }()
+
+	for _, hostname := range hostnames {
+		cmd.hostname = hostname
+		if _, err := hosts.Unmap(hostname); err != nil {
+			return a.error(cmd, err)
+		}
+	}
+	if err := hosts.Save(); err != nil {
+		return a.error(cmd, err)
+	}
+	return StatusSuccess
+}
+
+// envName returns the name of the environment variable holding the IP of
+// hostname, such as IP_SVC_TEST for svc.test.
+func envName(hostname string) string {
+	return "IP_" + strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		case 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		}
+		return '_'
+	}, hostname)
+}
@@ -0,0 +1,303 @@
+package cli
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lende/127/lib127"
+)
+
+// defaultServeAddr is the default address of the serve command.
+const defaultServeAddr = "127.0.0.1:8127"
+
+// mappingsPath is the path of the mappings collection of the JSON API.
+const mappingsPath = "/mappings"
+
+// maxBodySize is the maximum size of request bodies.
+const maxBodySize = 1 << 16
+
+func (a App) serveHTTP(cmd command) int {
+	if ap, err := netip.ParseAddrPort(cmd.listen); err != nil || !ap.Addr().IsLoopback() {
+		fmt.Fprintf(a.errorWriter(), "%s: not a loopback address: %s\n", a.name(), cmd.listen)
+		return StatusFailure
+	}
+
+	var token string
+	if cmd.tokenFile != "" {
+		b, err := os.ReadFile(cmd.tokenFile)
+		if err != nil {
+			return a.error(cmd, err)
+		}
+		if token = strings.TrimSpace(string(b)); token == "" {
+			fmt.Fprintf(a.errorWriter(), "%s: empty token file: %s\n", a.name(), cmd.tokenFile)
+			return StatusFailure
+		}
+	}
+
+	l, err := net.Listen("tcp", cmd.listen)
+	if err != nil {
+		return a.error(cmd, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Handler: &handler{
+			filename: cmd.filename,
+			token:    token,
+			opts: []lib127.Option{
+				lib127.WithLockTimeout(cmd.lockTimeout),
+				lib127.WithBackups(cmd.backupDir, lib127.DefaultBackups),
+			},
+		},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	context.AfterFunc(ctx, func() { _ = srv.Shutdown(context.Background()) })
+
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return a.error(cmd, err)
+	}
+	return StatusSuccess
+}
+
+// handler serves the JSON API of the serve command. Requests are handled one at
+// a time, and each of them opens the hosts file, and saves it before responding.
+type handler struct {
+	filename string
+	token    string
+	opts     []lib127.Option
+	mu       sync.Mutex
+}
+
+// hostMapping is the representation of a hostname in the JSON API.
+type hostMapping struct {
+	Hostname string           `json:"hostname"`
+	IPs      []string         `json:"ips"`
+	Replaced []lib127.Mapping `json:"replaced,omitempty"`
+}
+
+// mapRequest is the optional body of requests to map a hostname.
+type mapRequest struct {
+	IP string `json:"ip"`
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isLoopbackHost(r.Host) {
+		writeError(w, http.StatusForbidden, "host not allowed: "+r.Host)
+		return
+	}
+	if h.token != "" && !hasToken(r, h.token) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="127"`)
+		writeError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+		return
+	}
+
+	hostname, isHost := strings.CutPrefix(r.URL.Path, mappingsPath+"/")
+	switch {
+	case r.URL.Path == mappingsPath && r.Method == http.MethodGet:
+		h.serve(w, func(hosts *lib127.Hosts) (any, error) {
+			return listMappings(hosts, r.URL.Query().Get("all") == "true"), nil
+		})
+	case r.URL.Path == mappingsPath:
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed: "+r.Method)
+	case isHost && r.Method == http.MethodGet:
+		h.serve(w, func(hosts *lib127.Hosts) (any, error) {
+			return getMapping(hosts, hostname)
+		})
+	case isHost && r.Method == http.MethodPut:
+		var req mapRequest
+		if err := decodeBody(w, r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.serve(w, func(hosts *lib127.Hosts) (any, error) {
+			return putMapping(hosts, hostname, req.IP)
+		})
+	case isHost && r.Method == http.MethodDelete:
+		h.serve(w, func(hosts *lib127.Hosts) (any, error) {
+			return deleteMapping(hosts, hostname)
+		})
+	case isHost:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed: "+r.Method)
+	default:
+		writeError(w, http.StatusNotFound, "not found: "+r.URL.Path)
+	}
+}
+
+// serve opens the hosts file, calls fn and saves the changes, and writes the
+// result of fn as JSON. A nil result is written as a 404 Not Found error.
+func (h *handler) serve(w http.ResponseWriter, fn func(hosts *lib127.Hosts) (any, error)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hosts, err := lib127.Open(h.filename, h.opts...)
+	if err != nil {
+		writeError(w, errorStatus(err), errorMessage(err))
+		return
+	}
+	defer func() { _ = hosts.Close() }()
+
+	v, err := fn(hosts)
+	if err == nil {
+		err = hosts.Save()
+	}
+
+	switch {
+	case err != nil:
+		writeError(w, errorStatus(err), errorMessage(err))
+	case v == nil:
+		writeError(w, http.StatusNotFound, "hostname not mapped")
+	default:
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+func listMappings(hosts *lib127.Hosts, all bool) []lib127.Mapping {
+	scope := lib127.ScopeLoopback
+	if all {
+		scope = lib127.ScopeAll
+	}
+
+	mappings := append(hosts.Mappings(scope), hosts.Wildcards(scope)...)
+	if mappings == nil {
+		mappings = []lib127.Mapping{}
+	}
+	return mappings
+}
+
+func getMapping(hosts *lib127.Hosts, hostname string) (any, error) {
+	ips, err := hosts.IPs(hostname)
+	if err != nil || len(ips) == 0 {
+		return nil, err
+	}
+	return hostMapping{Hostname: hostname, IPs: ips}, nil
+}
+
+func putMapping(hosts *lib127.Hosts, hostname, ip string) (any, error) {
+	if lib127.IsWildcard(hostname) && ip == "" {
+		ip, err := hosts.MapWildcard(hostname)
+		if err != nil {
+			return nil, err
+		}
+		return hostMapping{Hostname: hostname, IPs: []string{ip}}, nil
+	}
+
+	var replaced []lib127.Mapping
+	var err error
+	if ip != "" {
+		replaced, err = hosts.MapTo(hostname, ip)
+	} else {
+		_, err = hosts.Map(hostname)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := hosts.IPs(hostname)
+	if err != nil {
+		return nil, err
+	}
+	return hostMapping{Hostname: hostname, IPs: ips, Replaced: replaced}, nil
+}
+
+func deleteMapping(hosts *lib127.Hosts, hostname string) (any, error) {
+	if lib127.IsWildcard(hostname) {
+		ip, err := hosts.UnmapWildcard(hostname)
+		if err != nil || ip == "" {
+			return nil, err
+		}
+		return hostMapping{Hostname: hostname, IPs: []string{ip}}, nil
+	}
+
+	ips, err := hosts.IPs(hostname)
+	if err != nil || len(ips) == 0 {
+		return nil, err
+	}
+	if _, err := hosts.Unmap(hostname); err != nil {
+		return nil, err
+	}
+	return hostMapping{Hostname: hostname, IPs: ips}, nil
+}
+
+// decodeBody decodes the JSON body of r into v, unless the body is empty.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{msg})
+}
+
+// errorStatus returns the HTTP status code reporting err.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, lib127.ErrHostnameInvalid), errors.Is(err, lib127.ErrInvalidIP):
+		return http.StatusBadRequest
+	case errors.Is(err, lib127.ErrCannotUnmapLocalhost),
+		errors.Is(err, lib127.ErrCannotRemapLocalhost), errors.Is(err, lib127.ErrNotManaged),
+		errors.Is(err, lib127.ErrIPInUse), errors.Is(err, lib127.ErrNoFreeAddress):
+		return http.StatusConflict
+	case errors.Is(err, lib127.ErrLocked):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorMessage returns the message reporting err.
+func errorMessage(err error) string {
+	return strings.TrimPrefix(err.Error(), "lib127: ")
+}
+
+// hasToken reports whether r is authorized by the bearer token.
+func hasToken(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// isLoopbackHost reports whether host, as given by the Host header, is a
+// loopback address or localhost. Other hosts are refused to guard against DNS
+// rebinding attacks by web pages.
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
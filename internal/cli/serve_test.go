@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lende/127/internal/testdata"
+	"github.com/lende/127/lib127"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	h := &handler{filename: testdata.HostsFile(t)}
+	for _, test := range []struct {
+		method, path, body string
+		status             int
+		want               string
+	}{
+		{"GET", "/mappings/loopback.test", "", 200,
+			`{"hostname": "loopback.test", "ips": ["127.0.0.3"]}`},
+		{"GET", "/mappings/missing.test", "", 404, `{"error": "hostname not mapped"}`},
+		{"GET", "/mappings/foo%2Fbar", "", 400,
+			`{"error": "get IP: hosts: adapt \"foo/bar\": idna: disallowed rune U+002F"}`},
+		{"PUT", "/mappings/new.test", `{"ip": "127.0.0.5"}`, 200,
+			`{"hostname": "new.test", "ips": ["127.0.0.5"]}`},
+		{"PUT", "/mappings/new.test", `{"ip": "127.0.0.6"}`, 200,
+			`{"hostname": "new.test", "ips": ["127.0.0.6"], "replaced": [` +
+				`{"ip": "127.0.0.5", "hostnames": ["new.test"], "line": 11, "managed": true}]}`},
+		{"PUT", "/mappings/new.test", "", 200,
+			`{"hostname": "new.test", "ips": ["127.0.0.6"]}`},
+		{"PUT", "/mappings/*.new.test", "", 200,
+			`{"hostname": "*.new.test", "ips": ["127.0.0.6"]}`},
+		{"PUT", "/mappings/other.test", `{"ip": "127.0.0.3"}`, 409,
+			`{"error": "map to 127.0.0.3: 127: IP address in use"}`},
+		{"PUT", "/mappings/other.test", `{"address": "127.0.0.3"}`, 400,
+			`{"error": "invalid request body: json: unknown field \"address\""}`},
+		{"DELETE", "/mappings/unmanaged.test", "", 409,
+			`{"error": "set hostname: hosts: unmap \"unmanaged.test\": hosts: record not managed"}`},
+		{"DELETE", "/mappings/new.test", "", 200,
+			`{"hostname": "new.test", "ips": ["127.0.0.6"]}`},
+		{"DELETE", "/mappings/new.test", "", 404, `{"error": "hostname not mapped"}`},
+		{"GET", "/mappings", "", 200, `[
+			{"ip": "127.0.0.1", "hostnames": ["localhost", "localhost.localdomain"],
+				"line": 1, "managed": false},
+			{"ip": "127.0.0.4", "hostnames": ["unmanaged.test"], "line": 2, "managed": false},
+			{"ip": "127.0.0.3", "hostnames": ["loopback.test"], "line": 10, "managed": true},
+			{"ip": "127.0.0.6", "hostnames": ["*.new.test"], "managed": true}]`},
+		{"POST", "/mappings", "", 405, `{"error": "method not allowed: POST"}`},
+		{"PATCH", "/mappings/new.test", "", 405, `{"error": "method not allowed: PATCH"}`},
+		{"GET", "/", "", 404, `{"error": "not found: /"}`},
+	} {
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		req.Host = "127.0.0.1:8127"
+		assertResponse(t, h, req, test.status, test.want)
+	}
+}
+
+func TestHandlerAuthorization(t *testing.T) {
+	t.Parallel()
+
+	h := &handler{
+		filename: testdata.HostsFile(t), token: "secret",
+		opts: []lib127.Option{lib127.WithLockTimeout(0)},
+	}
+	const found = `{"hostname": "loopback.test", "ips": ["127.0.0.3"]}`
+	for _, test := range []struct {
+		host, auth string
+		status     int
+		want       string
+	}{
+		{"localhost:8127", "Bearer secret", 200, found},
+		{"[::1]:8127", "Bearer secret", 200, found},
+		{"localhost:8127", "", 401, `{"error": "invalid or missing bearer token"}`},
+		{"localhost:8127", "Bearer wrong", 401, `{"error": "invalid or missing bearer token"}`},
+		{"attacker.example:8127", "Bearer secret", 403,
+			`{"error": "host not allowed: attacker.example:8127"}`},
+	} {
+		req := httptest.NewRequest("GET", "/mappings/loopback.test", nil)
+		req.Host = test.host
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		assertResponse(t, h, req, test.status, test.want)
+	}
+}
+
+func assertResponse(t *testing.T, h http.Handler, req *http.Request, status int, want string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != status {
+		t.Errorf("%s %s: want status: %d, got: %d", req.Method, req.URL.Path, status, rec.Code)
+	}
+	if got, want := compact(t, rec.Body.String()), compact(t, want); got != want {
+		t.Errorf("%s %s: want body: %s, got: %s", req.Method, req.URL.Path, want, got)
+	}
+}
+
+func compact(t *testing.T, s string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(s)); err != nil {
+		t.Fatalf("invalid JSON: %v: %s", err, s)
+	}
+	return buf.String()
+}
@@ -1,15 +1,19 @@
 package testdata
 
 import (
-	_ "embed"
+	"embed"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 )
 
 //go:embed hosts
 var hosts []byte
 
+//go:embed *.hosts
+var fixtures embed.FS
+
 // HostsFile creates a hosts file in a temporary directory and returns the path.
 func HostsFile(t *testing.T) string {
 	path := filepath.Join(t.TempDir(), "hosts")
@@ -18,3 +22,41 @@ func HostsFile(t *testing.T) string {
 	}
 	return path
 }
+
+// Fixture creates a hosts file in a temporary directory holding the named
+// fixture, such as "formatted.hosts", and returns the path.
+func Fixture(t *testing.T, name string) string {
+	b, err := fixtures.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return path
+}
+
+// AssertGolden reports an error unless got is equal to the content of the named
+// file in the golden directory. If update is true, the file is written instead.
+func AssertGolden(t *testing.T, name, got string, update bool) {
+	t.Helper()
+
+	_, file, _, _ := runtime.Caller(0)
+	path := filepath.Join(filepath.Dir(file), "golden", name)
+	if update {
+		if err := os.WriteFile(path, []byte(got), 0o600); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("Want content of %s:\n%s\nGot:\n%s", name, want, got)
+	}
+}
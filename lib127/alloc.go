@@ -0,0 +1,216 @@
+package lib127
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+
+	"github.com/lende/127/lib127/internal/hosts"
+)
+
+// An Allocator picks the IP addresses assigned to new mappings.
+type Allocator interface {
+	// Allocate returns an address from pool for which used returns false. The
+	// hostname is empty when the address is not for a particular hostname.
+	// Returns an error matching ErrNoFreeAddress if every address is used.
+	Allocate(hostname string, pool Pool, used func(netip.Addr) bool) (netip.Addr, error)
+}
+
+// A Pool is an inclusive range of IP addresses to allocate from.
+type Pool struct {
+	First, Last netip.Addr
+}
+
+// loopbackPrefix is the range of IPv4 loopback addresses.
+func loopbackPrefix() netip.Prefix {
+	return netip.PrefixFrom(netip.AddrFrom4([4]byte{127, 0, 0, 0}), 8)
+}
+
+// localhostAddr is the address of localhost, which is never allocated.
+func localhostAddr() netip.Addr {
+	return netip.AddrFrom4([4]byte{127, 0, 0, 1})
+}
+
+// ulaPrefix is the range of IPv6 unique local addresses. Addresses in this range
+// can be bound to the loopback interface, as IPv6 has a single loopback address.
+func ulaPrefix() netip.Prefix {
+	return netip.PrefixFrom(netip.AddrFrom16([16]byte{0: 0xfc}), 7)
+}
+
+// localhostPool6 is the default IPv6 pool, which only holds the IPv6 loopback
+// address. Every hostname mapped from it shares that address.
+func localhostPool6() Pool {
+	return Pool{First: netip.IPv6Loopback(), Last: netip.IPv6Loopback()}
+}
+
+// ParsePool parses a pool in CIDR notation, such as "127.42.0.0/16" or
+// "fd7f::/64". The first and last addresses are excluded from the pool, unless
+// the prefix is too long to have them. Returns an error matching ErrInvalidPool
+// if the pool is not within 127.0.0.0/8, or for IPv6, within fc00::/7 or ::1.
+func ParsePool(cidr string) (Pool, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return Pool{}, fmt.Errorf("lib127: parse pool: %w: %v", ErrInvalidPool, err)
+	}
+	prefix = prefix.Masked()
+
+	bits := prefix.Addr().BitLen() - prefix.Bits()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	pool := Pool{First: prefix.Addr()}
+	pool.Last = pool.Addr(size.Sub(size, big.NewInt(1)))
+
+	if bits > 1 {
+		pool.First, pool.Last = pool.First.Next(), pool.Last.Prev()
+	}
+
+	if err := pool.validate(); err != nil {
+		return Pool{}, fmt.Errorf("lib127: parse pool: %w", err)
+	}
+	return pool, nil
+}
+
+// validate returns an error matching ErrInvalidPool if the pool is not a valid
+// range of loopback addresses, unique local IPv6 addresses or ::1.
+func (p Pool) validate() error {
+	prefix := loopbackPrefix()
+	if p.First.Is6() {
+		prefix = ulaPrefix()
+	}
+
+	valid := p == localhostPool6() ||
+		(prefix.Contains(p.First) && prefix.Contains(p.Last) && p.First.Compare(p.Last) <= 0)
+	if !valid {
+		return fmt.Errorf("%w: %s", ErrInvalidPool, p)
+	}
+	return nil
+}
+
+// String returns the pool as a range of addresses.
+func (p Pool) String() string {
+	return p.First.String() + "-" + p.Last.String()
+}
+
+// defaultPool is the range of loopback addresses, except for the network
+// address, localhost and the broadcast address.
+func defaultPool() Pool {
+	return Pool{
+		First: netip.AddrFrom4([4]byte{127, 0, 0, 2}),
+		Last:  netip.AddrFrom4([4]byte{127, 255, 255, 254}),
+	}
+}
+
+// Size returns the number of addresses in the pool.
+func (p Pool) Size() *big.Int {
+	size := new(big.Int).Sub(addrToInt(p.Last), addrToInt(p.First))
+	return size.Add(size, big.NewInt(1))
+}
+
+// Addr returns the n-th address of the pool, counting from zero.
+func (p Pool) Addr(n *big.Int) netip.Addr {
+	return intToAddr(new(big.Int).Add(addrToInt(p.First), n), p.First.Is4())
+}
+
+// Contains reports whether addr is in the pool.
+func (p Pool) Contains(addr netip.Addr) bool {
+	return p.First.Compare(addr) <= 0 && addr.Compare(p.Last) <= 0
+}
+
+// next returns the address following addr, wrapping around at the end.
+func (p Pool) next(addr netip.Addr) netip.Addr {
+	if addr == p.Last {
+		return p.First
+	}
+	return addr.Next()
+}
+
+// randomAttempts is the number of random addresses RandomAllocator tries before
+// scanning for a free address.
+const randomAttempts = 32
+
+// RandomAllocator allocates addresses at random. This is the default. If no free
+// address is found after a number of attempts, the pool is scanned for one,
+// starting from a random address.
+type RandomAllocator struct {
+	// Rand is the source of randomness. If nil, a cryptographically secure
+	// random number generator is used.
+	Rand io.Reader
+}
+
+// Allocate returns a random unused address from pool.
+func (a RandomAllocator) Allocate(
+	_ string, pool Pool, used func(netip.Addr) bool,
+) (netip.Addr, error) {
+	r := a.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+
+	var addr netip.Addr
+	for i := 0; i < randomAttempts; i++ {
+		n, err := rand.Int(r, pool.Size())
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("random int: %w", err)
+		}
+
+		if addr = pool.Addr(n); !used(addr) {
+			return addr, nil
+		}
+	}
+
+	return probe(pool, addr, used)
+}
+
+// SequentialAllocator allocates the first unused address of the pool.
+type SequentialAllocator struct{}
+
+// Allocate returns the first unused address from pool.
+func (SequentialAllocator) Allocate(
+	_ string, pool Pool, used func(netip.Addr) bool,
+) (netip.Addr, error) {
+	return probe(pool, pool.First, used)
+}
+
+// HashAllocator derives addresses from a hash of the hostname, so that a
+// hostname is assigned the same address on every machine. If that address is
+// used, the following addresses are tried in order.
+type HashAllocator struct{}
+
+// Allocate returns the unused address from pool derived from hostname.
+func (HashAllocator) Allocate(
+	hostname string, pool Pool, used func(netip.Addr) bool,
+) (netip.Addr, error) {
+	name, err := hosts.AdaptHostname(hostname)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("hash hostname: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	n := new(big.Int).Mod(new(big.Int).SetBytes(sum[:]), pool.Size())
+	return probe(pool, pool.Addr(n), used)
+}
+
+// probe returns the first unused address of the pool, starting from start and
+// wrapping around at the end.
+func probe(pool Pool, start netip.Addr, used func(netip.Addr) bool) (netip.Addr, error) {
+	addr := start
+	for used(addr) {
+		if addr = pool.next(addr); addr == start {
+			return netip.Addr{}, fmt.Errorf("%w: %s", ErrNoFreeAddress, pool)
+		}
+	}
+	return addr, nil
+}
+
+func addrToInt(addr netip.Addr) *big.Int {
+	return new(big.Int).SetBytes(addr.AsSlice())
+}
+
+func intToAddr(n *big.Int, is4 bool) netip.Addr {
+	if is4 {
+		return netip.AddrFrom4([4]byte(n.FillBytes(make([]byte, 4))))
+	}
+	return netip.AddrFrom16([16]byte(n.FillBytes(make([]byte, 16))))
+}
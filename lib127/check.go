@@ -0,0 +1,169 @@
+package lib127
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/lende/127/lib127/internal/hosts"
+)
+
+// Severity is the severity of a Problem.
+type Severity int
+
+const (
+	// SeverityWarning indicates a problem that is likely unintended, but does
+	// not keep hostnames from resolving as mapped.
+	SeverityWarning Severity = iota
+
+	// SeverityError indicates a problem that keeps hostnames from resolving as
+	// mapped, or that keeps 127 from managing them.
+	SeverityError
+)
+
+// String returns "warning" or "error".
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// MarshalText encodes the severity as its string.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Problem is an issue with the hosts file found by Hosts.Check.
+type Problem struct {
+	Severity Severity `json:"severity"`
+	Line     int      `json:"line,omitempty"`
+	Hostname string   `json:"hostname,omitempty"`
+	IP       string   `json:"ip,omitempty"`
+	Message  string   `json:"message"`
+
+	// Fixable is true for problems that Hosts.Fix can fix safely. These are
+	// mappings in the managed block that never take effect, since the hostname
+	// is mapped to another address of the same IP version on an earlier line.
+	Fixable bool `json:"fixable"`
+
+	// record is the index of the record that a fix removes the hostname from.
+	record int
+}
+
+// Check validates the hosts file, and returns the problems found in the order
+// of their lines. Problems are:
+//   - malformed lines, which are ignored;
+//   - hostnames that fail IDNA conversion, or that are not in the ASCII form
+//     they are looked up in;
+//   - hostnames mapped more than once to addresses of the same IP version;
+//   - localhost mapped to addresses other than loopback addresses;
+//   - loopback addresses assigned to hostnames on several lines, other than the
+//     addresses of localhost.
+func (h *Hosts) Check() []Problem {
+	var problems []Problem
+	for _, w := range h.file.Warnings() {
+		problems = append(problems, Problem{
+			Severity: SeverityError, Line: w.Line,
+			Message: fmt.Sprintf("%s: %q", w.Reason, w.Text),
+		})
+	}
+
+	type mapping struct {
+		ip   string
+		line int
+	}
+	first := make(map[string][]mapping)
+	lines := make(map[string]int)
+	for i, r := range h.file.Records() {
+		problem := func(severity Severity, hostname, format string, a ...any) Problem {
+			return Problem{
+				Severity: severity, Line: r.Line, Hostname: hostname, IP: r.IP,
+				Message: fmt.Sprintf(format, a...), record: i,
+			}
+		}
+
+		addr, _ := netip.ParseAddr(r.IP)
+		if line, ok := lines[r.IP]; ok && h.isLoopback(r.IP) && !isLocalhostIP(addr) {
+			problems = append(problems, problem(SeverityWarning, "",
+				"%s is also assigned on line %d", r.IP, line))
+		} else if !ok {
+			lines[r.IP] = r.Line
+		}
+
+		for _, name := range r.Hostnames {
+			adapted, err := hosts.AdaptHostname(name)
+			switch {
+			case err != nil:
+				problems = append(problems, problem(SeverityError, name,
+					"invalid hostname: %s", strings.TrimPrefix(err.Error(), "hosts: ")))
+				continue
+			case adapted != name:
+				problems = append(problems, problem(SeverityWarning, name,
+					"%s is looked up as %s", name, adapted))
+			}
+
+			if isLocalhost(adapted) && !addr.IsLoopback() {
+				problems = append(problems, problem(SeverityError, name,
+					"%s mapped to non-loopback address %s", name, r.IP))
+			}
+
+			j := slices.IndexFunc(first[adapted], func(m mapping) bool {
+				return isIPv4(m.ip) == addr.Unmap().Is4()
+			})
+			switch {
+			case j < 0:
+				first[adapted] = append(first[adapted], mapping{ip: r.IP, line: r.Line})
+			case first[adapted][j].ip == r.IP:
+				p := problem(SeverityWarning, name, "%s mapped to %s again, first on line %d",
+					name, r.IP, first[adapted][j].line)
+				p.Fixable = r.Managed
+				problems = append(problems, p)
+			default:
+				p := problem(SeverityError, name,
+					"%s mapped to %s, but to %s on line %d first",
+					name, r.IP, first[adapted][j].ip, first[adapted][j].line)
+				p.Fixable = r.Managed
+				problems = append(problems, p)
+			}
+		}
+	}
+
+	slices.SortStableFunc(problems, func(a, b Problem) int { return a.Line - b.Line })
+	return problems
+}
+
+// Fix fixes the problems found by Check that are fixable, by removing mappings
+// from the managed block that never take effect. Returns the fixed problems.
+func (h *Hosts) Fix() ([]Problem, error) {
+	var fixed []Problem
+	for _, p := range h.Check() {
+		if p.Fixable {
+			fixed = append(fixed, p)
+		}
+	}
+
+	// Removing a record shifts the indexes of the records after it, so
+	// hostnames are removed from the last record first.
+	fixes := slices.Clone(fixed)
+	slices.SortStableFunc(fixes, func(a, b Problem) int { return b.record - a.record })
+	for _, p := range fixes {
+		if err := h.file.UnmapRecord(p.record, p.Hostname); err != nil {
+			return nil, wrapError("fix", err)
+		}
+		h.changed = true
+	}
+	return fixed, nil
+}
+
+func isIPv4(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && addr.Unmap().Is4()
+}
+
+// isLocalhostIP reports whether addr is 127.0.0.1 or ::1, which are shared by
+// localhost and its aliases.
+func isLocalhostIP(addr netip.Addr) bool {
+	return addr == localhostAddr() || addr == netip.IPv6Loopback()
+}
@@ -0,0 +1,329 @@
+// Package dns provides a DNS server answering queries for hostnames mapped by
+// lib127, including wildcard patterns that hosts files can not express. It is
+// meant to be used as the resolver of a local domain, such as .test.
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/lende/127/lib127"
+)
+
+// DefaultAddr is the default address the server listens on.
+const DefaultAddr = "127.0.0.1:53"
+
+// DefaultTTL is the default time that answers may be cached. It is short, so
+// that changes to the mappings are soon picked up.
+const DefaultTTL = 5 * time.Second
+
+// ErrNotLoopback indicates a listen address that is not a loopback address.
+var ErrNotLoopback = errors.New("dns: listen address not loopback")
+
+// tcpIdleTimeout is the time TCP connections are kept open between queries.
+const tcpIdleTimeout = 10 * time.Second
+
+// maxUDPSize is the maximum size of responses over UDP, for clients that do not
+// advertise a larger size. Larger responses are truncated.
+const maxUDPSize = 512
+
+// A Resolver looks up the IP addresses of hostnames. It is implemented by
+// *lib127.Hosts.
+type Resolver interface {
+	Resolve(hostname string) ([]string, error)
+}
+
+// HostsFile returns a Resolver that opens the named hosts file with the given
+// options for every lookup, so that changes to the mappings are answered
+// without restarting the server. The file is only locked during lookups.
+func HostsFile(filename string, opts ...lib127.Option) Resolver {
+	return hostsFile{filename: filename, opts: opts}
+}
+
+type hostsFile struct {
+	filename string
+	opts     []lib127.Option
+}
+
+// Resolve returns the IP addresses of hostname, as for lib127.Hosts.Resolve.
+func (f hostsFile) Resolve(hostname string) ([]string, error) {
+	hosts, err := lib127.Open(f.filename, f.opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = hosts.Close() }()
+
+	return hosts.Resolve(hostname)
+}
+
+// Server answers A and AAAA queries over UDP and TCP with the addresses found by
+// its resolver. Names that can not be resolved are answered with NXDOMAIN.
+type Server struct {
+	// Addr is the loopback address and port to listen on. DefaultAddr is used if
+	// empty.
+	Addr string
+
+	// Resolver looks up the addresses of queried names. The default hosts file
+	// is used if nil.
+	Resolver Resolver
+
+	// TTL is the time that answers may be cached. DefaultTTL is used if zero.
+	TTL time.Duration
+}
+
+// ListenAndServe listens on the loopback address of the server over UDP and
+// TCP, and answers queries until ctx is done. Returns an error matching
+// ErrNotLoopback if the address is not a loopback address.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	addr := s.Addr
+	if addr == "" {
+		addr = DefaultAddr
+	}
+
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return fmt.Errorf("dns: parse address: %w", err)
+	}
+	if !ap.Addr().IsLoopback() {
+		return fmt.Errorf("%w: %s", ErrNotLoopback, addr)
+	}
+
+	var lc net.ListenConfig
+	pc, err := lc.ListenPacket(ctx, "udp", addr)
+	if err != nil {
+		return fmt.Errorf("dns: listen: %w", err)
+	}
+	l, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		_ = pc.Close()
+		return fmt.Errorf("dns: listen: %w", err)
+	}
+
+	return s.Serve(ctx, pc, l)
+}
+
+// Serve answers queries received on pc and l until ctx is done, and then
+// closes them. Either of them may be nil. Returns nil when stopped by ctx.
+func (s *Server) Serve(ctx context.Context, pc net.PacketConn, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	if pc != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.serveUDP(ctx, pc)
+		}()
+	}
+	if l != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.serveTCP(ctx, l)
+		}()
+	}
+
+	// Stop serving once ctx is done, or either server fails.
+	go func() {
+		<-ctx.Done()
+		if pc != nil {
+			_ = pc.Close()
+		}
+		if l != nil {
+			_ = l.Close()
+		}
+	}()
+
+	var err error
+	go func() {
+		wg.Wait()
+		close(errs)
+	}()
+	for e := range errs {
+		if e != nil && err == nil {
+			err = e
+			cancel()
+		}
+	}
+	return err
+}
+
+func (s *Server) serveUDP(ctx context.Context, pc net.PacketConn) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("dns: read query: %w", err)
+		}
+
+		query := append([]byte(nil), buf[:n]...)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp, ok := s.answer(query, maxUDPSize); ok {
+				_, _ = pc.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP(ctx context.Context, l net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("dns: accept: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+// serveConn answers queries on a TCP connection until it is closed or idle.
+// Messages over TCP are prefixed by their length.
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	for {
+		if err := conn.SetDeadline(time.Now().Add(tcpIdleTimeout)); err != nil {
+			return
+		}
+
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+
+		resp, ok := s.answer(query, 0)
+		if !ok {
+			return
+		}
+		msg := binary.BigEndian.AppendUint16(nil, uint16(len(resp)))
+		if _, err := conn.Write(append(msg, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// answer returns the response to query, truncated to maxSize bytes if it is
+// not zero. Returns false if the query can not be answered at all.
+func (s *Server) answer(query []byte, maxSize int) ([]byte, bool) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil || h.Response {
+		return nil, false
+	}
+
+	resp := dnsmessage.Message{Header: dnsmessage.Header{
+		ID:               h.ID,
+		Response:         true,
+		OpCode:           h.OpCode,
+		Authoritative:    true,
+		RecursionDesired: h.RecursionDesired,
+	}}
+
+	q, err := p.Question()
+	switch {
+	case err != nil:
+		resp.RCode = dnsmessage.RCodeFormatError
+	case h.OpCode != 0:
+		resp.RCode = dnsmessage.RCodeNotImplemented
+	default:
+		resp.Questions = []dnsmessage.Question{q}
+		resp.Answers, resp.RCode = s.resolve(q)
+	}
+
+	b, err := resp.Pack()
+	if err == nil && maxSize > 0 && len(b) > maxSize {
+		resp.Truncated, resp.Answers = true, nil
+		b, err = resp.Pack()
+	}
+	return b, err == nil
+}
+
+// resolve returns the answers to q.
+func (s *Server) resolve(q dnsmessage.Question) ([]dnsmessage.Resource, dnsmessage.RCode) {
+	r := s.Resolver
+	if r == nil {
+		r = HostsFile(lib127.DefaultHostsFile)
+	}
+
+	ips, err := r.Resolve(strings.TrimSuffix(q.Name.String(), "."))
+	switch {
+	case errors.Is(err, lib127.ErrHostnameInvalid):
+		return nil, dnsmessage.RCodeNameError
+	case err != nil:
+		return nil, dnsmessage.RCodeServerFailure
+	case len(ips) == 0:
+		return nil, dnsmessage.RCodeNameError
+	}
+
+	// Names that resolve have no records of other types or classes.
+	if q.Class != dnsmessage.ClassINET && q.Class != dnsmessage.ClassANY {
+		return nil, dnsmessage.RCodeSuccess
+	}
+
+	var answers []dnsmessage.Resource
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+
+		hdr := dnsmessage.ResourceHeader{
+			Name: q.Name, Class: dnsmessage.ClassINET, TTL: s.ttl(),
+		}
+		switch {
+		case addr.Is4() && (q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeALL):
+			answers = append(answers, dnsmessage.Resource{
+				Header: hdr, Body: &dnsmessage.AResource{A: addr.As4()},
+			})
+		case addr.Is6() && (q.Type == dnsmessage.TypeAAAA || q.Type == dnsmessage.TypeALL):
+			answers = append(answers, dnsmessage.Resource{
+				Header: hdr, Body: &dnsmessage.AAAAResource{AAAA: addr.As16()},
+			})
+		}
+	}
+	return answers, dnsmessage.RCodeSuccess
+}
+
+// ttl returns the TTL of answers in seconds.
+func (s *Server) ttl() uint32 {
+	if s.TTL == 0 {
+		return uint32(DefaultTTL / time.Second)
+	}
+	return uint32(s.TTL / time.Second)
+}
@@ -0,0 +1,159 @@
+package dns_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/lende/127/internal/testdata"
+	"github.com/lende/127/lib127"
+	"github.com/lende/127/lib127/dns"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	path := testdata.HostsFile(t)
+	hosts, err := lib127.Open(path, lib127.WithFamily(lib127.DualStack))
+	requireNoError(t, err)
+	_, err = hosts.MapWildcard("*.loopback.test")
+	requireNoError(t, err)
+	dualIP, err := hosts.MapWildcard("*.dual.test")
+	requireNoError(t, err)
+	requireNoError(t, hosts.Save())
+	requireNoError(t, hosts.Close())
+
+	addr := serve(t, dns.HostsFile(path))
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+
+	for _, test := range []struct {
+		network, hostname string
+		want              []string
+	}{
+		{"ip4", "loopback.test", []string{"127.0.0.3"}},
+		{"ip4", "www.loopback.test", []string{"127.0.0.3"}},
+		{"ip4", "a.b.loopback.test", []string{"127.0.0.3"}},
+		{"ip6", "www.dual.test", []string{"::1"}},
+		{"ip", "www.dual.test", []string{dualIP, "::1"}},
+		{"ip4", "unmanaged.test", []string{"127.0.0.4"}},
+		{"ip4", "example.com", []string{"93.184.216.34"}},
+	} {
+		ips, err := r.LookupIP(context.Background(), test.network, test.hostname)
+		requireNoError(t, err)
+
+		var got []string
+		for _, ip := range ips {
+			got = append(got, ip.String())
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s %s: want IPs: %v, got: %v", test.network, test.hostname, test.want, got)
+		}
+	}
+
+	_, err = r.LookupIP(context.Background(), "ip4", "missing.test")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("want not found error, got: %v", err)
+	}
+}
+
+func TestServerTCP(t *testing.T) {
+	t.Parallel()
+
+	addr := serve(t, dns.HostsFile(testdata.HostsFile(t)))
+	conn, err := net.Dial("tcp", addr)
+	requireNoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	for _, test := range []struct {
+		name  string
+		rcode dnsmessage.RCode
+		ips   int
+	}{
+		{"loopback.test.", dnsmessage.RCodeSuccess, 1},
+		{"missing.test.", dnsmessage.RCodeNameError, 0},
+		{"foo_bar.test.", dnsmessage.RCodeNameError, 0},
+	} {
+		q := dnsmessage.Message{
+			Header: dnsmessage.Header{ID: 42},
+			Questions: []dnsmessage.Question{{
+				Name:  dnsmessage.MustNewName(test.name),
+				Type:  dnsmessage.TypeA,
+				Class: dnsmessage.ClassINET,
+			}},
+		}
+		b, err := q.Pack()
+		requireNoError(t, err)
+		_, err = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...))
+		requireNoError(t, err)
+
+		var size [2]byte
+		_, err = io.ReadFull(conn, size[:])
+		requireNoError(t, err)
+		b = make([]byte, binary.BigEndian.Uint16(size[:]))
+		_, err = io.ReadFull(conn, b)
+		requireNoError(t, err)
+
+		var resp dnsmessage.Message
+		requireNoError(t, resp.Unpack(b))
+		if resp.ID != 42 || !resp.Authoritative || resp.RCode != test.rcode ||
+			len(resp.Answers) != test.ips {
+			t.Errorf("%s: unexpected response: %+v", test.name, resp)
+		}
+	}
+}
+
+func TestListenAndServe(t *testing.T) {
+	t.Parallel()
+
+	s := dns.Server{Addr: "192.0.2.1:53"}
+	if err := s.ListenAndServe(context.Background()); !errors.Is(err, dns.ErrNotLoopback) {
+		t.Errorf("want error matching ErrNotLoopback, got: %v", err)
+	}
+}
+
+// serve starts a server on a random loopback port, which is stopped when the
+// test ends, and returns its address.
+func serve(t *testing.T, r dns.Resolver) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	requireNoError(t, err)
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	requireNoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		s := dns.Server{Resolver: r}
+		done <- s.Serve(ctx, pc, l)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		requireNoError(t, <-done)
+	})
+
+	return pc.LocalAddr().String()
+}
+
+func requireNoError(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
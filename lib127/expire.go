@@ -0,0 +1,24 @@
+package lib127
+
+import (
+	"time"
+
+	"github.com/lende/127/lib127/internal/hosts"
+)
+
+// Sweep removes the mappings that have expired by now, and returns them,
+// including those of profiles that are switched off. See WithTTL.
+func (h *Hosts) Sweep(now time.Time) []Mapping {
+	var expired []Mapping
+	for _, r := range h.file.RemoveRecords(func(r hosts.Record) bool {
+		expires := parseTime(r.Meta[expiresKey])
+		return expires != nil && !now.Before(*expires)
+	}) {
+		expired = append(expired, newMapping(r))
+	}
+
+	if len(expired) > 0 {
+		h.changed = true
+	}
+	return expired
+}
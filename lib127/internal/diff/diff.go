@@ -0,0 +1,220 @@
+// Package diff renders the differences between two texts as a unified diff.
+package diff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around changes.
+const context = 3
+
+// Kinds of edits in an edit script.
+const (
+	opKeep   = ' '
+	opDelete = '-'
+	opInsert = '+'
+)
+
+type edit struct {
+	kind byte
+	line string
+}
+
+// Unified returns a unified diff turning oldText into newText, with the given
+// file names in its header. Returns an empty string if the texts are equal.
+func Unified(oldName, newName, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+
+	edits := script(splitLines(oldText), splitLines(newText))
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+
+	// Positions in the old and new text before each edit.
+	oldPos, newPos := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	for i, e := range edits {
+		oldPos[i+1], newPos[i+1] = oldPos[i], newPos[i]
+		if e.kind != opInsert {
+			oldPos[i+1]++
+		}
+		if e.kind != opDelete {
+			newPos[i+1]++
+		}
+	}
+
+	for i := 0; i < len(edits); {
+		start := slices.IndexFunc(edits[i:], isChange)
+		if start < 0 {
+			break
+		}
+		start += i
+
+		// Extend the hunk until the next change is too far away to share context.
+		end := start
+		for j := start; j < len(edits) && j-end <= 2*context+1; j++ {
+			if isChange(edits[j]) {
+				end = j
+			}
+		}
+
+		first, last := max(start-context, i), min(end+context+1, len(edits))
+		writeHunk(&buf, edits[first:last], oldPos[first], oldPos[last], newPos[first], newPos[last])
+		i = last
+	}
+
+	return buf.String()
+}
+
+func isChange(e edit) bool {
+	return e.kind != opKeep
+}
+
+// writeHunk writes the edits spanning the lines from oldStart to oldEnd of the
+// old text, and from newStart to newEnd of the new text, counting from zero.
+func writeHunk(buf *strings.Builder, edits []edit, oldStart, oldEnd, newStart, newEnd int) {
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n",
+		hunkRange(oldStart, oldEnd), hunkRange(newStart, newEnd))
+
+	for _, e := range edits {
+		buf.WriteByte(e.kind)
+		buf.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats a range of lines of a hunk header. Empty ranges are given
+// by the line before them.
+func hunkRange(start, end int) string {
+	if start == end {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if end-start == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+// splitLines splits text into lines, keeping their line endings.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// maxEdits bounds the number of edits searched for by the Myers algorithm, as
+// its memory use grows with their square. Texts differing by more edits are
+// diffed as a replacement of the lines between their common prefix and suffix.
+const maxEdits = 1000
+
+// script returns an edit script turning a into b. Lines shared at the start and
+// end are kept, and the lines in between are diffed by myers.
+func script(a, b []string) []edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var edits []edit
+	for _, line := range a[:prefix] {
+		edits = append(edits, edit{opKeep, line})
+	}
+	edits = append(edits, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, edit{opKeep, line})
+	}
+	return edits
+}
+
+// myers returns the shortest edit script turning a into b, found with the Myers
+// diff algorithm, unless it takes more than maxEdits edits. Hosts files are
+// mostly left unchanged, so the number of edits is small.
+func myers(a, b []string) []edit {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// Record the furthest reaching paths before each round, for backtracking.
+	// Round d only reads the diagonals from -d-1 to d+1.
+	var trace [][]int
+	for d := 0; d <= min(n+m, maxEdits); d++ {
+		trace = append(trace, slices.Clone(v[offset-d-1:offset+d+2]))
+		for k := -d; k <= d; k += 2 {
+			x := v[offset+k-1] + 1
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(trace, a, b)
+			}
+		}
+	}
+	return replace(a, b)
+}
+
+// backtrack follows the furthest reaching paths of trace back from the end of a
+// and b, and returns the edits along the way. The paths of round d are given
+// for the diagonals from -d-1 to d+1.
+func backtrack(trace [][]int, a, b []string) []edit {
+	var edits []edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v, k := trace[d], x-y
+		at := func(diagonal int) int { return v[diagonal+d+1] }
+
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			edits = append(edits, edit{opKeep, a[x-1]})
+			x, y = x-1, y-1
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, edit{opInsert, b[y-1]})
+			} else {
+				edits = append(edits, edit{opDelete, a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	slices.Reverse(edits)
+	return edits
+}
+
+// replace returns the edit script deleting every line of a, and inserting every
+// line of b.
+func replace(a, b []string) []edit {
+	edits := make([]edit, 0, len(a)+len(b))
+	for _, line := range a {
+		edits = append(edits, edit{opDelete, line})
+	}
+	for _, line := range b {
+		edits = append(edits, edit{opInsert, line})
+	}
+	return edits
+}
@@ -0,0 +1,57 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name, old, new, want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"insert", "a\nb\n", "a\nx\nb\n", "--- old\n+++ new\n@@ -1,2 +1,3 @@\n a\n+x\n b\n"},
+		{"empty", "", "a\n", "--- old\n+++ new\n@@ -0,0 +1 @@\n+a\n"},
+		{"delete all", "a\n", "", "--- old\n+++ new\n@@ -1 +0,0 @@\n-a\n"},
+		{
+			"no newline", "a\nb", "a\nb\n",
+			"--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			"hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n", "1\nx\n3\n4\n5\n6\n7\n8\n9\n10\n11\ny\n",
+			"--- old\n+++ new\n@@ -1,5 +1,5 @@\n 1\n-2\n+x\n 3\n 4\n 5\n" +
+				"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+y\n",
+		},
+		{
+			"merged hunks", "1\n2\n3\n4\n5\n6\n7\n8\n", "x\n2\n3\n4\n5\n6\n7\ny\n",
+			"--- old\n+++ new\n@@ -1,8 +1,8 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+y\n",
+		},
+	} {
+		if got := Unified("old", "new", test.old, test.new); got != test.want {
+			t.Errorf("%s: want:\n%s\ngot:\n%s", test.name, test.want, got)
+		}
+	}
+}
+
+func TestUnifiedReplace(t *testing.T) {
+	t.Parallel()
+
+	// Texts differing by more than maxEdits edits are diffed as a replacement of
+	// the lines between their common prefix and suffix.
+	var oldText, newText strings.Builder
+	for i := 0; i < maxEdits; i++ {
+		fmt.Fprintf(&oldText, "a%d\n", i)
+		fmt.Fprintf(&newText, "b%d\n", i)
+	}
+	got := Unified("old", "new", "x\n"+oldText.String()+"y\n", "x\n"+newText.String()+"y\n")
+
+	header := fmt.Sprintf("--- old\n+++ new\n@@ -1,%[1]d +1,%[1]d @@\n x\n-a0\n", maxEdits+2)
+	footer := fmt.Sprintf("+b%d\n y\n", maxEdits-1)
+	if !strings.HasPrefix(got, header) || !strings.HasSuffix(got, footer) ||
+		strings.Count(got, "\n-a") != maxEdits || strings.Count(got, "\n+b") != maxEdits {
+		t.Errorf("Want replacement of every line but the first and last, got:\n%s", got)
+	}
+}
@@ -0,0 +1,110 @@
+package hosts
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// backupTimeFormat formats the time a backup was made in its name. The format
+// has a fixed width, so that names sort in the order the backups were made.
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// Backup is a copy of a hosts-file, made before the file was changed.
+type Backup struct {
+	Name string
+	Time time.Time
+}
+
+// Backups returns the backups of the named hosts-file kept in dir, newest
+// first. A missing directory holds no backups.
+func Backups(dir, filename string) ([]Backup, error) {
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("hosts: read backups: %w", err)
+	}
+
+	var backups []Backup
+	for _, e := range entries {
+		if t, ok := parseBackupName(e.Name(), filename); ok && e.Type().IsRegular() {
+			backups = append(backups, Backup{Name: e.Name(), Time: t})
+		}
+	}
+
+	// Entries are sorted by name, and thus from oldest to newest.
+	slices.Reverse(backups)
+	return backups, nil
+}
+
+// ReadBackup returns the content of the named backup of the hosts-file kept in
+// dir. Returns an error matching fs.ErrNotExist if there is no such backup.
+func ReadBackup(dir, filename, name string) (string, error) {
+	if _, ok := parseBackupName(name, filename); !ok {
+		return "", fmt.Errorf("hosts: read backup: %w",
+			&fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist})
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", fmt.Errorf("hosts: read backup: %w", err)
+	}
+	return string(b), nil
+}
+
+// backup copies the hosts-file as it was read into the backup directory, and
+// then removes the oldest backups exceeding the configured number.
+func (h File) backup() error {
+	if h.config.Backups <= 0 {
+		return nil
+	}
+
+	info, err := os.Stat(h.filename)
+	if err != nil {
+		return fmt.Errorf("hosts: stat file: %w", err)
+	}
+	dir := filepath.Clean(h.config.BackupDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("hosts: create backup directory: %w", err)
+	}
+
+	name := backupPrefix(h.filename) + time.Now().UTC().Format(backupTimeFormat)
+	err = os.WriteFile(filepath.Join(dir, name), []byte(h.original), info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("hosts: write backup: %w", err)
+	}
+
+	backups, err := Backups(dir, h.filename)
+	if err != nil {
+		return err
+	}
+	for _, b := range backups[min(h.config.Backups, len(backups)):] {
+		if err := os.Remove(filepath.Join(dir, b.Name)); err != nil {
+			return fmt.Errorf("hosts: remove backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// backupPrefix returns the prefix of the names of backups of the named file.
+func backupPrefix(filename string) string {
+	return filepath.Base(filename) + "-"
+}
+
+// parseBackupName returns the time a backup of the named file was made, as
+// given by its name. Returns false if name is not the name of such a backup.
+func parseBackupName(name, filename string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, backupPrefix(filename))
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeFormat, stamp)
+	return t, err == nil
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package hosts
+
+import (
+	"io/fs"
+	"os"
+)
+
+// chown does nothing on systems without Unix file ownership.
+func chown(*os.File, fs.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package hosts
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// chown changes the owner of f to that described by info, if it differs.
+func chown(f *os.File, info fs.FileInfo) error {
+	fInfo, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("hosts: stat: %w", err)
+	}
+
+	want, ok := info.Sys().(*syscall.Stat_t)
+	got, _ := fInfo.Sys().(*syscall.Stat_t)
+	if !ok || got == nil || (want.Uid == got.Uid && want.Gid == got.Gid) {
+		return nil
+	}
+
+	if err := f.Chown(int(want.Uid), int(want.Gid)); err != nil {
+		return fmt.Errorf("hosts: chown: %w", err)
+	}
+	return nil
+}
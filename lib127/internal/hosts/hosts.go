@@ -3,12 +3,18 @@ package hosts
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"net"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
-	hostsfile "github.com/kevinburke/hostsfile/lib"
 	"golang.org/x/net/idna"
+
+	"github.com/lende/127/lib127/internal/diff"
 )
 
 // These errors can be tested against using errors.Is. They are never returned
@@ -19,115 +25,715 @@ var (
 
 	// ErrHostnameIsIP indicates that the hostname is an IP address.
 	ErrHostnameIsIP = errors.New("hosts: hostname is IP address")
+
+	// ErrNotManaged indicates that a record is outside the managed block.
+	ErrNotManaged = errors.New("hosts: record not managed")
+)
+
+// Markers delimiting the block of records managed by 127.
+const (
+	BeginMarker = "# BEGIN 127 MANAGED BLOCK"
+	EndMarker   = "# END 127 MANAGED BLOCK"
 )
 
-// Record represent a single line from a hosts-file.
-type Record hostsfile.Record
+// Record represent a single line from a hosts-file that maps an IP address to
+// one or more hostnames.
+type Record struct {
+	IP        string
+	Hostnames []string
+	Comment   string
+	Meta      Meta
+	Line      int
+	Managed   bool
+	Disabled  bool
+}
 
-// File is an in-memory representation of a hosts-file.
+// File is an in-memory representation of a hosts-file, as a list of lines.
+// Lines that are not changed are saved exactly as they were read, and changes
+// only touch the lines concerned, so that formatting, comments and the order of
+// records are preserved. New records are added at the end of the managed block.
 type File struct {
-	hostsfile hostsfile.Hostsfile
-	filename  string
+	filename string
+	original string
+	lines    []line
+	warnings []*ParseError
+	ips      map[string]int
+	lock     *lock
+	config   Config
 }
 
-// Open opens the hosts-file and returns a representation.
-func Open(filename string) (*File, error) {
-	f, err := os.Open(filepath.Clean(filename))
+// Config configures how a hosts-file is opened and saved.
+type Config struct {
+	// LockTimeout is the time to wait for other processes to release the lock.
+	LockTimeout time.Duration
+
+	// BackupDir is the directory in which backups are kept.
+	BackupDir string
+
+	// Backups is the number of backups to keep. Every save makes a backup of
+	// the file as it was read, and the oldest backups beyond this number are
+	// removed. No backups are made if zero.
+	Backups int
+}
+
+// Wildcard maps every subdomain matching a pattern, such as "*.app.test", to an
+// IP address. Hosts-files can not express wildcards, so they are kept as
+// commented out records in the managed block.
+type Wildcard struct {
+	IP      string
+	Pattern string
+}
+
+// Open locks the hosts-file and returns a representation. Waits at most
+// config.LockTimeout for other processes to release the lock. The lock is held
+// until the file is closed.
+func Open(filename string, config Config) (*File, error) {
+	l, err := acquireLock(filename, config.LockTimeout)
 	if err != nil {
-		return nil, fmt.Errorf("hosts: open file: %w", err)
+		return nil, err
 	}
-	defer f.Close()
 
-	h, err := hostsfile.Decode(f)
+	b, err := os.ReadFile(filepath.Clean(filename))
 	if err != nil {
-		return nil, fmt.Errorf("hosts: decode file: %v", err)
+		_ = l.release()
+		return nil, fmt.Errorf("hosts: open file: %w", err)
+	}
+
+	h := File{filename: filename, original: string(b), lock: l, config: config}
+	if err := h.decode(string(b)); err != nil {
+		_ = l.release()
+		return nil, err
 	}
-	return &File{h, filename}, nil
+	return &h, nil
 }
 
-// HasIP returns true if the ip exists in the hosts file.
-func (h File) HasIP(ip string) bool {
-	for _, r := range h.Records() {
-		if r.IpAddress.IP.String() == ip {
-			return true
+// Close releases the lock on the hosts-file.
+func (h File) Close() error {
+	return h.lock.release()
+}
+
+// decode parses the lines of content. Malformed lines are kept as they are,
+// and reported by Warnings. Returns a *ParseError if the managed block is
+// never ended, as the file can then not be changed safely.
+func (h *File) decode(content string) error {
+	h.lines, h.warnings = nil, nil
+	for i, raw := range splitLines(content) {
+		l, err := parseLine(raw, i+1)
+		if err != nil {
+			err.File = h.filename
+			h.warnings = append(h.warnings, err)
 		}
+		h.lines = append(h.lines, l)
 	}
-	return false
+
+	if begin := slices.IndexFunc(h.lines, isMarker(BeginMarker)); begin >= 0 {
+		if !slices.ContainsFunc(h.lines[begin:], isMarker(EndMarker)) {
+			raw := h.lines[begin].raw
+			return &ParseError{
+				File: h.filename, Line: begin + 1,
+				Column: strings.Index(raw, BeginMarker) + 1, Text: BeginMarker,
+				Reason: "managed block is never ended",
+			}
+		}
+	}
+
+	h.index()
+	return nil
+}
+
+// Warnings returns the errors of the malformed lines of the file, as it was
+// read. Malformed lines are not records, and are saved as they were read.
+func (h File) Warnings() []*ParseError {
+	return slices.Clone(h.warnings)
+}
+
+// index builds the index of IP addresses mapped in the file, counting the lines
+// that map each of them. Changes keep the index up to date with setLine, so it
+// is only built when the file is read.
+func (h *File) index() {
+	h.ips = make(map[string]int, len(h.lines))
+	for _, l := range h.lines {
+		h.count(recordIP(l), 1)
+	}
+	for _, w := range h.Wildcards() {
+		h.count(w.IP, 1)
+	}
+}
+
+// count adds n to the number of lines mapping ip, if any.
+func (h *File) count(ip string, n int) {
+	if ip == "" {
+		return
+	}
+	if h.ips[ip] += n; h.ips[ip] <= 0 {
+		delete(h.ips, ip)
+	}
+}
+
+// setLine replaces the line at index i with l, updating the index. Lines are
+// marked for removal by compact by replacing them with an empty line.
+func (h *File) setLine(i int, l line) {
+	h.count(recordIP(h.lines[i]), -1)
+	h.count(recordIP(l), 1)
+	h.lines[i] = l
+}
+
+// recordIP returns the IP address of the record on l, whether switched off or
+// not. Returns an empty string if l holds no record.
+func recordIP(l line) string {
+	if l.isRecord() {
+		return l.ip
+	}
+	if e, ok := l.enabled(); ok {
+		return e.ip
+	}
+	return ""
+}
+
+// block returns the indexes of the lines holding the markers of the managed
+// block. Returns false if there is no managed block.
+func (h File) block() (begin, end int, ok bool) {
+	begin = slices.IndexFunc(h.lines, isMarker(BeginMarker))
+	if begin < 0 {
+		return 0, 0, false
+	}
+	end = begin + slices.IndexFunc(h.lines[begin:], isMarker(EndMarker))
+	return begin, end, true
+}
+
+// ensureBlock returns the indexes of the markers of the managed block, adding
+// an empty block at the end of the file if there is none.
+func (h *File) ensureBlock() (begin, end int) {
+	if begin, end, ok := h.block(); ok {
+		return begin, end
+	}
+
+	eol := lineEnding(h.lines)
+	if n := len(h.lines); n > 0 && !strings.HasSuffix(h.lines[n-1].raw, "\n") {
+		h.lines[n-1].raw += eol
+	}
+	h.lines = append(h.lines, line{raw: BeginMarker + eol}, line{raw: EndMarker + eol})
+	return len(h.lines) - 2, len(h.lines) - 1
+}
+
+// managed returns a function reporting whether the line at index i is inside
+// the managed block. The block is only looked up once, so the function must not
+// be used once lines are added or removed.
+func (h File) managed() func(i int) bool {
+	begin, end, ok := h.block()
+	return func(i int) bool { return ok && begin < i && i < end }
+}
+
+// Clone returns a deep copy of the file, sharing its lock. Lines are never
+// modified in place, so they are shared as well.
+func (h File) Clone() (*File, error) {
+	c := h
+	c.lines = slices.Clone(h.lines)
+	c.ips = maps.Clone(h.ips)
+	return &c, nil
+}
+
+func isMarker(marker string) func(line) bool {
+	return func(l line) bool {
+		return strings.TrimSpace(l.raw) == marker
+	}
+}
+
+// HasIP returns true if the ip is mapped in the hosts file, or reserved by a
+// disabled record. The IP addresses are indexed, so the records are not scanned.
+func (h File) HasIP(ip string) bool {
+	return h.ips[ip] > 0
 }
 
-// IP returns the IP address associated with the given hostname, if any.
+// IP returns the first IP address associated with the given hostname, if any.
 func (h File) IP(hostname string) (string, error) {
-	adaptedName, err := adaptHostname(hostname)
-	if err != nil {
+	ips, err := h.IPs(hostname)
+	if err != nil || len(ips) == 0 {
 		return "", err
 	}
+	return ips[0], nil
+}
+
+// IPs returns every IP address associated with the given hostname, in order.
+func (h File) IPs(hostname string) ([]string, error) {
+	adaptedName, err := AdaptHostname(hostname)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, r := range h.hostsfile.Records() {
-		if r.Hostnames[adaptedName] {
-			return r.IpAddress.String(), nil
+	var ips []string
+	for _, l := range h.lines {
+		if l.has(adaptedName) {
+			ips = append(ips, l.ip)
 		}
 	}
-	return "", nil
+	return ips, nil
 }
 
-// Records returns an array of all entries in the hosts-file.
-func (h File) Records() []*Record {
-	hostsfileRecs := h.hostsfile.Records()
-	recs := make([]*Record, 0, len(hostsfileRecs))
+// Records returns an array of all entries in the hosts-file, in order, with
+// their hostnames in the order they appear. Records added since the file was
+// opened have line number 0.
+func (h File) Records() []Record {
+	isManaged := h.managed()
+	var recs []Record
+	for i, l := range h.lines {
+		if l.isRecord() {
+			recs = append(recs, record(l, isManaged(i)))
+		}
+	}
+	return recs
+}
+
+// record returns the record on the line l.
+func record(l line, managed bool) Record {
+	return Record{
+		IP:        l.ip,
+		Hostnames: slices.Clone(l.hostnames),
+		Comment:   l.comment(),
+		Meta:      l.meta(),
+		Line:      l.number,
+		Managed:   managed,
+	}
+}
+
+// Disabled returns the records of the managed block switched off by
+// DisableRecords, in order.
+func (h File) Disabled() []Record {
+	begin, end, ok := h.block()
+	var recs []Record
+	for i := begin + 1; ok && i < end; i++ {
+		if l, disabled := h.lines[i].enabled(); disabled {
+			r := record(l, true)
+			r.Disabled = true
+			recs = append(recs, r)
+		}
+	}
+	return recs
+}
+
+// DisableRecords switches off the records of the managed block that match, and
+// returns them. Records are switched off by commenting them out, and must have
+// metadata to be told apart from other comments. Their IP addresses stay
+// reserved, see HasIP.
+func (h *File) DisableRecords(match func(Record) bool) []Record {
+	begin, end, ok := h.block()
+	var recs []Record
+	for i := begin + 1; ok && i < end; i++ {
+		l := h.lines[i]
+		if !l.isRecord() || l.meta() == nil {
+			continue
+		}
+		if r := record(l, true); match(r) {
+			h.setLine(i, l.disabled())
+			recs = append(recs, r)
+		}
+	}
+	return recs
+}
+
+// EnableRecords switches the disabled records that match back on, and returns
+// them.
+func (h *File) EnableRecords(match func(Record) bool) []Record {
+	begin, end, ok := h.block()
+	var recs []Record
+	for i := begin + 1; ok && i < end; i++ {
+		l, disabled := h.lines[i].enabled()
+		if !disabled {
+			continue
+		}
+
+		r := record(l, true)
+		r.Disabled = true
+		if match(r) {
+			h.setLine(i, l)
+			recs = append(recs, r)
+		}
+	}
+	return recs
+}
 
-	for _, r := range hostsfileRecs {
-		if len(r.Hostnames) == 0 {
+// RemoveRecords removes the records of the managed block that match, whether
+// disabled or not, and returns them.
+func (h *File) RemoveRecords(match func(Record) bool) []Record {
+	begin, end, ok := h.block()
+	var recs []Record
+	for i := begin + 1; ok && i < end; i++ {
+		l, disabled := h.lines[i].enabled()
+		if !disabled {
+			l = h.lines[i]
+		}
+		if !l.isRecord() {
 			continue
 		}
 
-		recs = append(recs, (*Record)(r))
+		r := record(l, true)
+		r.Disabled = disabled
+		if match(r) {
+			h.setLine(i, line{})
+			recs = append(recs, r)
+		}
 	}
+	h.compact()
 	return recs
 }
 
-// Map maps the specified hostname to the given IP.
+// Map maps the specified hostname to the given IP inside the managed block.
+// Existing mappings of the hostname to addresses of the other IP version are
+// kept.
 func (h *File) Map(hostname, ip string) error {
-	adaptedName, err := adaptHostname(hostname)
+	adaptedName, err := AdaptHostname(hostname)
 	if err != nil {
 		return err
 	}
-
-	if err := h.hostsfile.Set(net.IPAddr{IP: net.ParseIP(ip)}, adaptedName); err != nil {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
 		return fmt.Errorf("hosts: set hostname: %v", err)
 	}
+	ip = addr.Unmap().String()
+
+	begin, end := h.ensureBlock()
+	mapped := slices.ContainsFunc(h.lines[begin+1:end], func(l line) bool {
+		return l.has(adaptedName) && l.ip == ip
+	})
+	for i := begin + 1; i < end; i++ {
+		l := h.lines[i]
+		if !l.has(adaptedName) || l.ip == ip || isIPv4(l.ip) != isIPv4(ip) {
+			continue
+		}
+
+		// Remapping a record of the hostname alone keeps it in place.
+		if !mapped && len(l.hostnames) == 1 {
+			h.setLine(i, l.withIP(ip))
+			mapped = true
+		} else {
+			h.removeHostname(i, adaptedName)
+		}
+	}
+	h.compact()
+
+	if !mapped {
+		h.insert(ip + " " + adaptedName)
+	}
 	return nil
 }
 
-// Unmap removes the given hostname mapping.
-func (h *File) Unmap(hostname string) error {
-	adaptedName, err := adaptHostname(hostname)
+// Unmap removes the given hostname mapping, including from records switched off
+// by DisableRecords. Unless force is true, an error matching ErrNotManaged is
+// returned if the hostname is mapped outside the managed block.
+func (h *File) Unmap(hostname string, force bool) error {
+	adaptedName, err := AdaptHostname(hostname)
 	if err != nil {
 		return err
 	}
 
-	h.hostsfile.Remove(adaptedName)
+	if !force && h.hasUnmanaged(adaptedName) {
+		return fmt.Errorf("hosts: unmap %q: %w", hostname, ErrNotManaged)
+	}
+
+	isManaged := h.managed()
+	for i, l := range h.lines {
+		e, disabled := l.enabled()
+		if l.has(adaptedName) || (disabled && isManaged(i) && e.has(adaptedName)) {
+			h.removeHostname(i, adaptedName)
+		}
+	}
+	h.compact()
 	return nil
 }
 
-// Save saves the changes to the hosts-file.
-func (h File) Save() error {
-	f, err := os.OpenFile(h.filename, os.O_WRONLY|os.O_TRUNC, 0)
+// UnmapIP removes the mapping of the given hostname to ip, keeping mappings of
+// the hostname to other addresses, including from records switched off by
+// DisableRecords. Unless force is true, an error matching ErrNotManaged is
+// returned if the mapping is outside the managed block.
+func (h *File) UnmapIP(hostname, ip string, force bool) error {
+	adaptedName, err := AdaptHostname(hostname)
 	if err != nil {
-		return fmt.Errorf("hosts: open file: %w", err)
+		return err
 	}
 
-	if err := hostsfile.Encode(f, h.hostsfile); err != nil {
-		_ = f.Close()
-		return fmt.Errorf("hosts: encode file: %v", err)
+	mapped := func(l line) bool { return l.has(adaptedName) && l.ip == ip }
+	isManaged := h.managed()
+	for i, l := range h.lines {
+		if mapped(l) && !force && !isManaged(i) {
+			return fmt.Errorf("hosts: unmap %q from %s: %w", hostname, ip, ErrNotManaged)
+		}
 	}
 
-	if err := f.Close(); err != nil {
-		return fmt.Errorf("hosts: close file: %w", err)
+	for i, l := range h.lines {
+		e, disabled := l.enabled()
+		if mapped(l) || (disabled && isManaged(i) && mapped(e)) {
+			h.removeHostname(i, adaptedName)
+		}
 	}
+	h.compact()
+	return nil
+}
+
+// UnmapRecord removes hostname from the i-th record returned by Records,
+// regardless of whether it is managed. The record is removed if no hostnames
+// are left.
+func (h *File) UnmapRecord(i int, hostname string) error {
+	n := i
+	for j, l := range h.lines {
+		if !l.isRecord() {
+			continue
+		}
+		if n > 0 {
+			n--
+			continue
+		}
 
+		if l.has(hostname) {
+			h.removeHostname(j, hostname)
+			h.compact()
+		}
+		return nil
+	}
+	return fmt.Errorf("hosts: unmap %q from record %d: no such record", hostname, i)
+}
+
+// SetMeta updates the metadata of the record of the managed block that maps
+// hostname to ip. The given keys are set, or removed if their value is empty,
+// and other keys are kept.
+func (h *File) SetMeta(hostname, ip string, meta Meta) error {
+	adaptedName, err := AdaptHostname(hostname)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return fmt.Errorf("hosts: set metadata: %v", err)
+	}
+	ip = addr.Unmap().String()
+
+	begin, end, ok := h.block()
+	for i := begin + 1; ok && i < end; i++ {
+		l := h.lines[i]
+		if !l.has(adaptedName) || l.ip != ip {
+			continue
+		}
+
+		m := l.meta()
+		if m == nil {
+			m = Meta{}
+		}
+		for k, v := range meta {
+			if v == "" {
+				delete(m, k)
+			} else {
+				m[k] = v
+			}
+		}
+		h.setLine(i, l.withMeta(m))
+		return nil
+	}
+	return fmt.Errorf("hosts: set metadata of %q: no managed record for %s", hostname, ip)
+}
+
+// Alias adds alias to every record mapping the given hostname, so that both
+// names share the addresses. Unless force is true, an error matching
+// ErrNotManaged is returned if such a record is outside the managed block.
+func (h *File) Alias(hostname, alias string, force bool) error {
+	adaptedName, err := AdaptHostname(hostname)
+	if err != nil {
+		return err
+	}
+	adaptedAlias, err := AdaptHostname(alias)
+	if err != nil {
+		return err
+	}
+
+	if !force && h.hasUnmanaged(adaptedName) {
+		return fmt.Errorf("hosts: alias %q: %w", hostname, ErrNotManaged)
+	}
+
+	for i, l := range h.lines {
+		if l.has(adaptedName) && !l.has(adaptedAlias) {
+			h.setLine(i, l.withHostname(adaptedAlias))
+		}
+	}
+	return nil
+}
+
+// Wildcards returns the wildcards of the managed block, in order.
+func (h File) Wildcards() []Wildcard {
+	begin, end, ok := h.block()
+	if !ok {
+		return nil
+	}
+
+	var wildcards []Wildcard
+	for _, l := range h.lines[begin+1 : end] {
+		if w, ok := parseWildcard(l.raw); ok {
+			wildcards = append(wildcards, w)
+		}
+	}
+	return wildcards
+}
+
+// MapWildcard maps subdomains matching pattern to the given IP inside the
+// managed block. Existing mappings of the pattern to addresses of the other IP
+// version are kept.
+func (h *File) MapWildcard(pattern, ip string) error {
+	adaptedPattern, err := AdaptPattern(pattern)
+	if err != nil {
+		return err
+	}
+
+	is4 := isIPv4(ip)
+	h.removeWildcards(func(w Wildcard) bool {
+		return w.Pattern == adaptedPattern && isIPv4(w.IP) == is4
+	})
+
+	_, end := h.ensureBlock()
+	h.lines = slices.Insert(h.lines, end,
+		line{raw: "# " + ip + " " + adaptedPattern + lineEnding(h.lines)})
+	h.count(ip, 1)
+	return nil
+}
+
+// UnmapWildcard removes every mapping of the given pattern.
+func (h *File) UnmapWildcard(pattern string) error {
+	adaptedPattern, err := AdaptPattern(pattern)
+	if err != nil {
+		return err
+	}
+
+	h.removeWildcards(func(w Wildcard) bool { return w.Pattern == adaptedPattern })
+	return nil
+}
+
+// removeWildcards removes the lines of the wildcards for which del returns true.
+func (h *File) removeWildcards(del func(Wildcard) bool) {
+	begin, end, ok := h.block()
+	if !ok {
+		return
+	}
+
+	for i := begin + 1; i < end; i++ {
+		if w, ok := parseWildcard(h.lines[i].raw); ok && del(w) {
+			h.lines[i].raw = ""
+			h.count(w.IP, -1)
+		}
+	}
+	h.compact()
+}
+
+// hasUnmanaged reports whether hostname is mapped outside the managed block.
+func (h File) hasUnmanaged(hostname string) bool {
+	isManaged := h.managed()
+	for i, l := range h.lines {
+		if l.has(hostname) && !isManaged(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeHostname removes hostname from the record at index i, whether switched
+// off or not. Records left without hostnames are marked for removal by compact.
+func (h *File) removeHostname(i int, hostname string) {
+	l, disabled := h.lines[i].enabled()
+	if !disabled {
+		l = h.lines[i]
+	}
+
+	l = l.withoutHostname(hostname)
+	switch {
+	case !l.isRecord():
+		l = line{}
+	case disabled:
+		l = l.disabled()
+	}
+	h.setLine(i, l)
+}
+
+// compact removes the lines marked for removal. Lines are never empty
+// otherwise, as each of them holds at least a line ending, unless it is the
+// last line of the file.
+func (h *File) compact() {
+	h.lines = slices.DeleteFunc(h.lines, func(l line) bool { return l.raw == "" })
+}
+
+// insert adds a record to the managed block, after its other records and
+// before its wildcards.
+func (h *File) insert(record string) {
+	begin, end := h.ensureBlock()
+	i := begin + 1
+	for j := begin + 1; j < end; j++ {
+		if h.lines[j].isRecord() {
+			i = j + 1
+		}
+	}
+
+	l, _ := parseLine(record+lineEnding(h.lines), 0)
+	h.lines = slices.Insert(h.lines, i, l)
+	h.count(l.ip, 1)
+}
+
+// Save saves the changes to the hosts-file. The content is written to a
+// temporary file which then replaces the hosts-file, so that a failure never
+// leaves the file partially written. Files that cannot be replaced, such as
+// bind-mounted files, are overwritten in place. If backups are configured, the
+// file as it was read is backed up first.
+func (h *File) Save() error {
+	filename, err := filepath.EvalSymlinks(h.filename)
+	if err != nil {
+		return fmt.Errorf("hosts: resolve file: %w", err)
+	}
+
+	content := h.encode()
+	if content != h.original {
+		if err := h.backup(); err != nil {
+			return err
+		}
+	}
+
+	err = replaceFile(filename, []byte(content))
+	if errors.Is(err, errCannotReplace) {
+		err = overwriteFile(filename, []byte(content))
+	}
+	if err != nil {
+		return err
+	}
+
+	// Later diffs are against the saved content.
+	h.original = content
+	return nil
+}
+
+// Restore replaces the lines of the file with those of content, such as that
+// of a backup. The file is saved exactly as content.
+func (h *File) Restore(content string) error {
+	r := File{filename: h.filename, original: h.original, lock: h.lock, config: h.config}
+	if err := r.decode(content); err != nil {
+		return err
+	}
+	*h = r
 	return nil
 }
 
+// Diff returns the changes Save would make to the hosts-file as it was read, as
+// a unified diff. Returns an empty string if there are no changes.
+func (h File) Diff() string {
+	return diff.Unified(h.filename, h.filename, h.original, h.encode())
+}
+
+// encode returns the content of the hosts-file.
+func (h File) encode() string {
+	var b strings.Builder
+	for _, l := range h.lines {
+		b.WriteString(l.raw)
+	}
+	return b.String()
+}
+
+// isIPv4 reports whether ip is an IPv4 address.
+func isIPv4(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && addr.Unmap().Is4()
+}
+
 type hostnameError struct {
 	format, hostname string
 	isIP             bool
@@ -149,9 +755,9 @@ func (e hostnameError) Is(err error) bool {
 	return e.isIP && err == ErrHostnameIsIP
 }
 
-// adaptHostname validates the given hostname and converts it from unicode to
+// AdaptHostname validates the given hostname and converts it from unicode to
 // IDNA Punycode.
-func adaptHostname(hostname string) (string, error) {
+func AdaptHostname(hostname string) (string, error) {
 	if hostname == "" {
 		return "", hostnameError{
 			format:   "hosts: check %q: hostname is empty",
@@ -176,3 +782,42 @@ func adaptHostname(hostname string) (string, error) {
 	}
 	return h, nil
 }
+
+// AdaptPattern validates the given wildcard pattern, such as "*.app.test", and
+// converts the domain it applies to from unicode to IDNA Punycode.
+func AdaptPattern(pattern string) (string, error) {
+	domain, ok := strings.CutPrefix(pattern, wildcardPrefix)
+	if !ok {
+		return "", hostnameError{
+			format:   "hosts: check %q: not a wildcard pattern",
+			hostname: pattern,
+		}
+	}
+
+	adaptedDomain, err := AdaptHostname(domain)
+	if err != nil {
+		return "", err
+	}
+	return wildcardPrefix + adaptedDomain, nil
+}
+
+// wildcardPrefix is the prefix of wildcard patterns.
+const wildcardPrefix = "*."
+
+// parseWildcard parses a commented out record of a wildcard, such as
+// "# 127.0.0.2 *.app.test".
+func parseWildcard(line string) (Wildcard, bool) {
+	comment, ok := strings.CutPrefix(strings.TrimSpace(line), "#")
+	if !ok {
+		return Wildcard{}, false
+	}
+
+	fields := strings.Fields(comment)
+	if len(fields) != 2 || net.ParseIP(fields[0]) == nil {
+		return Wildcard{}, false
+	}
+	if _, err := AdaptPattern(fields[1]); err != nil {
+		return Wildcard{}, false
+	}
+	return Wildcard{IP: fields[0], Pattern: fields[1]}, true
+}
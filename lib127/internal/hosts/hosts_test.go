@@ -0,0 +1,154 @@
+package hosts_test
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/lende/127/internal/testdata"
+	"github.com/lende/127/lib127/internal/hosts"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestFormatting(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name, fixture string
+		edit          func(f *hosts.File) error
+	}{
+		{"unchanged", "formatted.hosts", func(*hosts.File) error { return nil }},
+		{"map", "formatted.hosts", func(f *hosts.File) error {
+			return f.Map("new.test", "127.0.0.6")
+		}},
+		{"remap", "formatted.hosts", func(f *hosts.File) error {
+			if err := f.Map("db.test", "127.0.0.7"); err != nil {
+				return err
+			}
+			return f.Map("api.loopback.test", "127.0.0.8")
+		}},
+		{"unmap", "formatted.hosts", func(f *hosts.File) error {
+			if err := f.Unmap("api.loopback.test", false); err != nil {
+				return err
+			}
+			return f.Unmap("db.test", false)
+		}},
+		{"unmap-unmanaged", "formatted.hosts", func(f *hosts.File) error {
+			if err := f.Unmap("legacy.test", true); err != nil {
+				return err
+			}
+			return f.UnmapIP("localhost", "::1", true)
+		}},
+		{"alias", "formatted.hosts", func(f *hosts.File) error {
+			if err := f.Alias("loopback.test", "www.loopback.test", false); err != nil {
+				return err
+			}
+			return f.Alias("localhost", "loopback", true)
+		}},
+		{"wildcard", "formatted.hosts", func(f *hosts.File) error {
+			if err := f.MapWildcard("*.db.test", "127.0.0.5"); err != nil {
+				return err
+			}
+			return f.UnmapWildcard("*.loopback.test")
+		}},
+		{"meta", "formatted.hosts", func(f *hosts.File) error {
+			meta := hosts.Meta{"expires": "2026-10-16T10:00:00Z", "note": `for "CI"`}
+			if err := f.SetMeta("api.loopback.test", "127.0.0.3", meta); err != nil {
+				return err
+			}
+			if err := f.SetMeta("db.test", "127.0.0.5", meta); err != nil {
+				return err
+			}
+			return f.SetMeta("db.test", "127.0.0.5", hosts.Meta{"expires": "", "owner": "ci"})
+		}},
+		{"disable", "formatted.hosts", func(f *hosts.File) error {
+			if err := f.SetMeta("db.test", "127.0.0.5", hosts.Meta{"profile": "db"}); err != nil {
+				return err
+			}
+			inDB := func(r hosts.Record) bool { return r.Meta["profile"] == "db" }
+			if got := f.DisableRecords(inDB); len(got) != 1 || !f.HasIP("127.0.0.5") {
+				return fmt.Errorf("want db.test disabled keeping its IP, got: %v", got)
+			}
+			if got := f.EnableRecords(inDB); len(got) != 1 {
+				return fmt.Errorf("want db.test enabled, got: %v", got)
+			}
+			f.DisableRecords(inDB)
+			return f.Map("new.test", "127.0.0.6")
+		}},
+		{"malformed", "malformed.hosts", func(f *hosts.File) error {
+			if err := f.Map("new.test", "127.0.0.6"); err != nil {
+				return err
+			}
+			return f.Unmap("loopback.test", false)
+		}},
+		{"new-block", "crlf.hosts", func(f *hosts.File) error {
+			if err := f.Map("new.test", "127.0.0.6"); err != nil {
+				return err
+			}
+			return f.MapWildcard("*.new.test", "127.0.0.6")
+		}},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := testdata.Fixture(t, test.fixture)
+			f, err := hosts.Open(path, hosts.Config{})
+			requireNoError(t, err)
+			defer func() { _ = f.Close() }()
+
+			requireNoError(t, test.edit(f))
+			requireNoError(t, f.Save())
+
+			b, err := os.ReadFile(path)
+			requireNoError(t, err)
+			testdata.AssertGolden(t, test.name+".hosts", string(b), *update)
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	t.Parallel()
+
+	path := testdata.Fixture(t, "malformed.hosts")
+	f, err := hosts.Open(path, hosts.Config{})
+	requireNoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	want := []*hosts.ParseError{
+		{File: path, Line: 2, Column: 1, Text: "localhost", Reason: "invalid IP address"},
+		{File: path, Line: 3, Column: 1, Text: "127.0.0.2", Reason: "missing hostname"},
+		{File: path, Line: 4, Column: 3, Text: "300.1.2.3", Reason: "invalid IP address"},
+		{File: path, Line: 8, Column: 1, Text: "127.0.0.3.4", Reason: "invalid IP address"},
+	}
+	if got := f.Warnings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want warnings: %v, got: %v", want, got)
+	}
+	if got, want := len(f.Records()), 2; got != want {
+		t.Errorf("Want %d records, got: %d", want, got)
+	}
+
+	path = testdata.Fixture(t, "crlf.hosts")
+	requireNoError(t, os.WriteFile(path, []byte("127.0.0.1 localhost\n"+hosts.BeginMarker), 0o600))
+	_, err = hosts.Open(path, hosts.Config{})
+	var parseErr *hosts.ParseError
+	if !errors.As(err, &parseErr) || parseErr.Line != 2 || parseErr.Column != 1 {
+		t.Errorf("Want ParseError at line 2, column 1, got: %v", err)
+	}
+	if want := "hosts: " + path + `:2:1: managed block is never ended: "` +
+		hosts.BeginMarker + `"`; err == nil || err.Error() != want {
+		t.Errorf("Want error: %s, got: %v", want, err)
+	}
+}
+
+func requireNoError(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package hosts
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// line is a single line of a hosts-file, including its line ending. Lines are
+// saved exactly as they were read, unless changed. Editing a line only touches
+// the hostnames concerned, keeping its whitespace and comment.
+type line struct {
+	raw    string
+	number int
+
+	// The record on the line, if any. Lines that are blank or only hold a
+	// comment have no IP address.
+	ip        string
+	hostnames []string
+}
+
+// ParseError describes a malformed line of a hosts-file.
+type ParseError struct {
+	File   string // Name of the hosts-file.
+	Line   int    // Line number, starting at 1.
+	Column int    // Column of Text in bytes, starting at 1.
+	Text   string // The offending text.
+	Reason string // Description of the problem.
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("hosts: %s:%d:%d: %s: %q", e.File, e.Line, e.Column, e.Reason, e.Text)
+}
+
+// span holds the offsets of a field of a line.
+type span struct {
+	start, end int
+}
+
+// parseLine parses the record on a line, if any. Malformed lines are returned
+// as lines without a record, along with a ParseError lacking the file name.
+func parseLine(raw string, number int) (line, *ParseError) {
+	l := line{raw: raw, number: number}
+
+	fields := scanFields(raw)
+	if len(fields) == 0 {
+		return l, nil
+	}
+
+	ip := raw[fields[0].start:fields[0].end]
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return l, &ParseError{
+			Line: number, Column: fields[0].start + 1, Text: ip,
+			Reason: "invalid IP address",
+		}
+	}
+	if len(fields) == 1 {
+		return l, &ParseError{
+			Line: number, Column: fields[0].start + 1, Text: ip,
+			Reason: "missing hostname",
+		}
+	}
+
+	l.ip = addr.Unmap().String()
+	l.hostnames = hostnames(raw, fields)
+	return l, nil
+}
+
+// hostnames returns the distinct hostnames among the fields of a record.
+func hostnames(raw string, fields []span) []string {
+	var names []string
+	for _, f := range fields[1:] {
+		if name := raw[f.start:f.end]; !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// scanFields returns the fields of a line, up to any comment. A comment starts
+// with a field beginning with '#'.
+func scanFields(raw string) []span {
+	var fields []span
+	start := -1
+	for i, r := range raw {
+		switch {
+		case unicode.IsSpace(r) && start >= 0:
+			fields = append(fields, span{start, i})
+			start = -1
+		case unicode.IsSpace(r):
+		case start < 0 && r == '#':
+			return fields
+		case start < 0:
+			start = i
+		}
+	}
+	if start >= 0 {
+		fields = append(fields, span{start, len(raw)})
+	}
+	return fields
+}
+
+// isRecord reports whether the line holds a record with at least one hostname.
+func (l line) isRecord() bool {
+	return len(l.hostnames) > 0
+}
+
+func (l line) has(hostname string) bool {
+	return slices.Contains(l.hostnames, hostname)
+}
+
+// comment returns the comment trailing the record on the line, if any, other
+// than its metadata.
+func (l line) comment() string {
+	if i := metaIndex(l.raw); i >= 0 {
+		return inlineComment(l.raw[:i])
+	}
+	return inlineComment(l.raw)
+}
+
+// meta returns the metadata of the record on the line, or nil if it has none.
+func (l line) meta() Meta {
+	i := metaIndex(l.raw)
+	if i < 0 {
+		return nil
+	}
+	m, _ := parseMeta(strings.TrimRight(l.raw[i:], "\r\n"))
+	return m
+}
+
+// withMeta returns the line with its metadata replaced by m, after any other
+// comment. Empty metadata is removed.
+func (l line) withMeta(m Meta) line {
+	body := strings.TrimRight(l.raw, "\r\n")
+	ending := l.raw[len(body):]
+	if i := metaIndex(body); i >= 0 {
+		body = strings.TrimRightFunc(body[:i], unicode.IsSpace)
+	}
+	if len(m) > 0 {
+		body += " " + m.String()
+	}
+	return l.edited(body + ending)
+}
+
+// withoutHostname returns the line with every occurrence of hostname removed,
+// along with the whitespace following it, or preceding it if it is the last
+// field. This keeps the whitespace between the other fields.
+func (l line) withoutHostname(hostname string) line {
+	fields := scanFields(l.raw)
+	raw, last := l.raw, len(fields)-1
+	for i := last; i > 0; i-- {
+		f := fields[i]
+		switch {
+		case raw[f.start:f.end] != hostname:
+		case i < last:
+			// If the next field was removed, the one after it now starts there.
+			raw = raw[:f.start] + raw[fields[i+1].start:]
+		default:
+			raw = raw[:fields[i-1].end] + raw[f.end:]
+			last--
+		}
+	}
+	return l.edited(raw)
+}
+
+// withHostname returns the line with hostname added after its last hostname,
+// separated by the same whitespace as the last hostname is.
+func (l line) withHostname(hostname string) line {
+	fields := scanFields(l.raw)
+	last, prev := fields[len(fields)-1], fields[len(fields)-2]
+	sep := l.raw[prev.end:last.start]
+	return l.edited(l.raw[:last.end] + sep + hostname + l.raw[last.end:])
+}
+
+// withIP returns the line with its IP address replaced by ip.
+func (l line) withIP(ip string) line {
+	f := scanFields(l.raw)[0]
+	e := l.edited(l.raw[:f.start] + ip + l.raw[f.end:])
+	e.ip = ip
+	return e
+}
+
+// edited returns the line with the given raw text. The record of the line is
+// updated without parsing raw again, since only its fields are ever edited.
+func (l line) edited(raw string) line {
+	return line{
+		raw: raw, number: l.number,
+		ip: l.ip, hostnames: hostnames(raw, scanFields(raw)),
+	}
+}
+
+// disabledPrefix comments out the records switched off by disabled.
+const disabledPrefix = "# "
+
+// disabled returns the line with its record switched off, by commenting it out.
+func (l line) disabled() line {
+	return line{raw: disabledPrefix + l.raw, number: l.number}
+}
+
+// enabled returns the record switched off on the line by disabled, and true.
+// Switched off records are told apart from other comments by their metadata.
+func (l line) enabled() (line, bool) {
+	raw, ok := strings.CutPrefix(l.raw, disabledPrefix)
+	if !ok {
+		return line{}, false
+	}
+	e, err := parseLine(raw, l.number)
+	if err != nil || !e.isRecord() || e.meta() == nil {
+		return line{}, false
+	}
+	return e, true
+}
+
+// splitLines splits content into lines, keeping their line endings.
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// lineEnding returns the line ending used by lines, which is "\n" unless the
+// first line ends with "\r\n".
+func lineEnding(lines []line) string {
+	if len(lines) > 0 && strings.HasSuffix(lines[0].raw, "\r\n") {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// inlineComment returns the comment trailing a record line, if any.
+func inlineComment(line string) string {
+	inField := false
+	for i, r := range line {
+		switch {
+		case unicode.IsSpace(r):
+			inField = false
+		case !inField && r == '#':
+			return strings.TrimSpace(strings.TrimLeft(line[i:], "#"))
+		default:
+			inField = true
+		}
+	}
+	return ""
+}
@@ -0,0 +1,162 @@
+package hosts
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMeta(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		raw, comment string
+		meta         Meta
+	}{
+		{"127.0.0.2 a.test\n", "", nil},
+		{"127.0.0.2 a.test # 127: \n", "", Meta{}},
+		{"127.0.0.2 a.test # Comment.\n", "Comment.", nil},
+		{"127.0.0.2 a.test # 127: a=1 b=\"x y\"\r\n", "", Meta{"a": "1", "b": "x y"}},
+		{"127.0.0.2 a.test # Comment. # 127: a=\"\"\n", "Comment.", Meta{"a": ""}},
+		{"127.0.0.2 a.test # 127: not metadata\n", "127: not metadata", nil},
+		{"127.0.0.2 a.test # 127: a=\"unterminated\n", `127: a="unterminated`, nil},
+	} {
+		l, err := parseLine(test.raw, 1)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got := l.comment(); got != test.comment {
+			t.Errorf("%q: want comment: %q, got: %q", test.raw, test.comment, got)
+		}
+		if got := l.meta(); !reflect.DeepEqual(got, test.meta) {
+			t.Errorf("%q: want metadata: %v, got: %v", test.raw, test.meta, got)
+		}
+
+		// Metadata is replaced after any other comment.
+		meta := Meta{"note": "say \"hi\"\n", "z": "1"}
+		e := l.withMeta(meta)
+		if got := e.meta(); !reflect.DeepEqual(got, meta) {
+			t.Errorf("%q: want metadata: %v, got: %v in %q", test.raw, meta, got, e.raw)
+		}
+		if got := e.comment(); got != test.comment {
+			t.Errorf("%q: want comment: %q, got: %q in %q", test.raw, test.comment, got, e.raw)
+		}
+		if got := e.withMeta(nil).withMeta(meta).raw; got != e.raw {
+			t.Errorf("%q: want line: %q, got: %q", test.raw, e.raw, got)
+		}
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		raw       string
+		hostnames []string
+		reason    string
+	}{
+		{"127.0.0.2 a.test b.test a.test\n", []string{"a.test", "b.test"}, ""},
+		{"127.0.0.2\ta.test # b.test\n", []string{"a.test"}, ""},
+		{"# 127.0.0.2 a.test\n", nil, ""},
+		{"127.0.0.2\n", nil, "missing hostname"},
+		{"127.0.0.2 # Comment.\n", nil, "missing hostname"},
+		{"a.test 127.0.0.2\n", nil, "invalid IP address"},
+	} {
+		l, err := parseLine(test.raw, 1)
+		if got := l.hostnames; !slices.Equal(got, test.hostnames) {
+			t.Errorf("%q: want hostnames: %q, got: %q", test.raw, test.hostnames, got)
+		}
+		var reason string
+		if err != nil {
+			reason = err.Reason
+		}
+		if reason != test.reason {
+			t.Errorf("%q: want error: %q, got: %q", test.raw, test.reason, reason)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	fixtures, err := filepath.Glob("../../../internal/testdata/*hosts")
+	if err != nil {
+		f.Fatalf("Unexpected error: %v", err)
+	}
+	for _, name := range fixtures {
+		b, err := os.ReadFile(filepath.Clean(name))
+		if err != nil {
+			f.Fatalf("Unexpected error: %v", err)
+		}
+		f.Add(string(b))
+	}
+	f.Add("127.0.0.1\tfuzz.test fuzz.test # Duplicate.\r\n::ffff:127.0.0.1 fuzz.test")
+	f.Add("fuzz.test 127.0.0.1\n127.0.0.1\n" + BeginMarker)
+	f.Add(BeginMarker + "\n127.0.0.2 fuzz.test # 127: note=\"\\\"\"\n" + EndMarker)
+
+	f.Fuzz(func(t *testing.T, content string) {
+		var h File
+		err := h.decode(content)
+		var parseErr *ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			t.Fatalf("Want ParseError, got: %v", err)
+		}
+		if err != nil {
+			return
+		}
+
+		// Unchanged files are encoded exactly as they were read.
+		if got := h.encode(); got != content {
+			t.Fatalf("Want unchanged content: %q, got: %q", content, got)
+		}
+
+		// Changes are only made to records, and can be read back.
+		if err := h.Map("fuzz.test", "127.0.0.2"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := h.Alias("fuzz.test", "alias.fuzz.test", true); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := h.SetMeta("fuzz.test", "127.0.0.2", Meta{"note": content}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var r File
+		if err := r.decode(h.encode()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(r.warnings) != len(h.warnings) {
+			t.Fatalf("Want warnings: %v, got: %v", h.warnings, r.warnings)
+		}
+		if ips, _ := r.IPs("alias.fuzz.test"); !slices.Contains(ips, "127.0.0.2") {
+			t.Fatalf("Want alias mapped to 127.0.0.2, got: %v in %q", ips, h.encode())
+		}
+		if i := slices.IndexFunc(r.Records(), func(r Record) bool {
+			return r.Managed && r.Meta["note"] == content
+		}); i < 0 {
+			t.Fatalf("Want metadata note: %q, got: %q", content, h.encode())
+		}
+
+		if err := r.Unmap("alias.fuzz.test", true); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if strings.Contains(r.encode(), "alias.fuzz.test") {
+			t.Fatalf("Want alias unmapped, got: %q", r.encode())
+		}
+
+		// The index is kept up to date by every change.
+		all := func(Record) bool { return true }
+		r.DisableRecords(all)
+		if err := r.MapWildcard("*.fuzz.test", "127.0.0.3"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		r.EnableRecords(all)
+		r.RemoveRecords(func(r Record) bool { return r.IP == "127.0.0.2" })
+		ips := r.ips
+		if r.index(); !maps.Equal(r.ips, ips) {
+			t.Fatalf("Want index: %v, got: %v in %q", r.ips, ips, r.encode())
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package hosts
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ErrLocked indicates that the hosts-file is locked by another process. It can
+// be tested against using errors.Is, and is never returned directly.
+var ErrLocked = errors.New("hosts: file is locked")
+
+// lockInterval is the time between attempts to acquire a lock.
+const lockInterval = 50 * time.Millisecond
+
+// lock holds an advisory lock on a lock file next to the hosts-file. The
+// hosts-file itself can not be locked, since it is replaced when saved.
+type lock struct {
+	file *os.File
+}
+
+// acquireLock locks the lock file for the named hosts-file, waiting at most
+// timeout for other processes to release it. Returns an error matching
+// ErrLocked on timeout.
+//
+// Processes that are not allowed to create the lock file are not allowed to
+// modify the hosts-file either, so they proceed without a lock. The same goes
+// for missing directories, in which case opening the hosts-file will fail.
+func acquireLock(filename string, timeout time.Duration) (*lock, error) {
+	if path, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = path
+	}
+
+	name := filename + ".lock"
+	f, err := os.OpenFile(filepath.Clean(name), os.O_RDONLY|os.O_CREATE, 0o600)
+	if errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) ||
+		errors.Is(err, syscall.EROFS) {
+		return &lock{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("hosts: open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := tryLock(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("hosts: lock %s: %w", name, err)
+		}
+		if ok {
+			return &lock{f}, nil
+		}
+
+		if time.Now().After(deadline) {
+			_ = f.Close()
+			return nil, fmt.Errorf("hosts: lock %s: %w", name, ErrLocked)
+		}
+		time.Sleep(lockInterval)
+	}
+}
+
+// release releases the lock. Does nothing if the lock is already released.
+func (l *lock) release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+
+	f := l.file
+	l.file = nil
+
+	// Closing the file releases the lock.
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("hosts: close lock file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package hosts
+
+import "os"
+
+// tryLock does nothing on systems without flock, and always succeeds.
+func tryLock(*os.File) (bool, error) {
+	return true, nil
+}
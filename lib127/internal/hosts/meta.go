@@ -0,0 +1,106 @@
+package hosts
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// metaMarker starts the comment holding the metadata of a record.
+const metaMarker = "# 127:"
+
+// Meta is the metadata of a record, as key-value pairs. It is kept in a comment
+// trailing the record, after any other comment, such as:
+//
+//	127.0.0.2 app.test # 127: expires=2026-10-16T10:00:00Z note="for CI"
+//
+// Keys are written in sorted order, and values are quoted if needed.
+type Meta map[string]string
+
+// String returns the metadata comment of m.
+func (m Meta) String() string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var b strings.Builder
+	b.WriteString(metaMarker)
+	for _, k := range keys {
+		v := m[k]
+		if v == "" || strings.ContainsFunc(v, needsQuote) {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(" " + k + "=" + v)
+	}
+	return b.String()
+}
+
+func needsQuote(r rune) bool {
+	return unicode.IsSpace(r) || r == '"' || !unicode.IsPrint(r)
+}
+
+// parseMeta parses a metadata comment. Comments that merely start like one are
+// not metadata.
+func parseMeta(comment string) (Meta, bool) {
+	s, ok := strings.CutPrefix(comment, metaMarker)
+	if !ok {
+		return nil, false
+	}
+
+	m := Meta{}
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return m, true
+		}
+
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok || key == "" || strings.ContainsFunc(key, needsQuote) {
+			return nil, false
+		}
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, false
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			i := strings.IndexFunc(rest, unicode.IsSpace)
+			if i < 0 {
+				i = len(rest)
+			}
+			value, rest = rest[:i], rest[i:]
+		}
+
+		m[key] = value
+		s = rest
+	}
+}
+
+// metaIndex returns the offset of the metadata comment of a record line, or -1
+// if it has none. The metadata comment is the first one that is well-formed up
+// to the end of the line.
+func metaIndex(raw string) int {
+	start := 0
+	if fields := scanFields(raw); len(fields) > 0 {
+		start = fields[len(fields)-1].end
+	}
+
+	for {
+		i := strings.Index(raw[start:], metaMarker)
+		if i < 0 {
+			return -1
+		}
+		start += i
+		if _, ok := parseMeta(strings.TrimRight(raw[start:], "\r\n")); ok {
+			return start
+		}
+		start += len(metaMarker)
+	}
+}
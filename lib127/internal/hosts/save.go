@@ -0,0 +1,112 @@
+package hosts
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// errCannotReplace indicates that a file can not be replaced, but may still be
+// overwritten.
+var errCannotReplace = errors.New("hosts: cannot replace file")
+
+// replaceFile atomically replaces the named file with a file holding content,
+// preserving the mode and owner of the original. Returns an error matching
+// errCannotReplace if the file could be overwritten in place instead.
+func replaceFile(filename string, content []byte) (err error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("hosts: stat file: %w", err)
+	}
+
+	dir := filepath.Dir(filename)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*.tmp")
+	if errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("%w: %w", errCannotReplace, err)
+	}
+	if err != nil {
+		return fmt.Errorf("hosts: create temporary file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if _, err := f.Write(content); err != nil {
+		return fmt.Errorf("hosts: write temporary file: %w", err)
+	}
+	if err := f.Chmod(info.Mode().Perm()); err != nil {
+		return fmt.Errorf("hosts: chmod temporary file: %w", err)
+	}
+	if err := chown(f, info); errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("%w: %w", errCannotReplace, err)
+	} else if err != nil {
+		return fmt.Errorf("hosts: chown temporary file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("hosts: sync temporary file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("hosts: close temporary file: %w", err)
+	}
+
+	// Renaming fails for bind-mounted files, such as /etc/hosts in containers.
+	err = os.Rename(f.Name(), filename)
+	if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EXDEV) {
+		return fmt.Errorf("%w: %w", errCannotReplace, err)
+	}
+	if err != nil {
+		return fmt.Errorf("hosts: rename temporary file: %w", err)
+	}
+
+	return syncDir(dir)
+}
+
+// overwriteFile writes content to the named file in place. The file is only
+// truncated after the new content has been written, so it is never left empty.
+func overwriteFile(filename string, content []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("hosts: open file: %w", err)
+	}
+
+	if _, err := f.WriteAt(content, 0); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("hosts: write file: %w", err)
+	}
+	if err := f.Truncate(int64(len(content))); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("hosts: truncate file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("hosts: sync file: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("hosts: close file: %w", err)
+	}
+	return nil
+}
+
+// syncDir flushes the directory entry of a renamed file to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(filepath.Clean(dir))
+	if err != nil {
+		return fmt.Errorf("hosts: open directory: %w", err)
+	}
+
+	// Not all file systems support syncing directories, and the rename has
+	// already succeeded, so sync errors are ignored.
+	_ = d.Sync()
+
+	if err := d.Close(); err != nil {
+		return fmt.Errorf("hosts: close directory: %w", err)
+	}
+	return nil
+}
@@ -1,23 +1,24 @@
 package lib127
 
 import (
-	"encoding/binary"
-	"net"
+	"math/big"
 	"testing"
 )
 
-func TestConstants(t *testing.T) {
+func TestDefaultPool(t *testing.T) {
 	t.Parallel()
 
-	ip := make(net.IP, net.IPv4len)
-
-	binary.BigEndian.PutUint32(ip, minIP)
-	if ip.String() != "127.0.0.2" {
-		t.Errorf("minIP: unexpected constant value: %d (%s)", minIP, ip)
+	pool := defaultPool()
+	if first := pool.First.String(); first != "127.0.0.2" {
+		t.Errorf("defaultPool: unexpected first address: %s", first)
 	}
-
-	binary.BigEndian.PutUint32(ip, maxIP)
-	if ip.String() != "127.255.255.254" {
-		t.Errorf("maxIP: unexpected constant value: %d (%s)", maxIP, ip)
+	if last := pool.Last.String(); last != "127.255.255.254" {
+		t.Errorf("defaultPool: unexpected last address: %s", last)
+	}
+	if size := pool.Size(); size.Cmp(big.NewInt(1<<24-3)) != 0 {
+		t.Errorf("defaultPool: unexpected size: %d", size)
+	}
+	if addr := pool.Addr(big.NewInt(256)).String(); addr != "127.0.1.2" {
+		t.Errorf("defaultPool: unexpected address: %s", addr)
 	}
 }
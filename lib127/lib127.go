@@ -3,13 +3,13 @@
 package lib127
 
 import (
-	"crypto/rand"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io/fs"
-	"math/big"
-	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/lende/127/lib127/internal/hosts"
 )
@@ -17,6 +17,13 @@ import (
 // DefaultHostsFile is the default hosts file location.
 const DefaultHostsFile = "/etc/hosts"
 
+// DefaultLockTimeout is the default time to wait for other processes to release
+// the hosts file.
+const DefaultLockTimeout = 10 * time.Second
+
+// DefaultBackups is a sensible number of backups to keep with WithBackups.
+const DefaultBackups = 10
+
 // These errors can be tested against using errors.Is. They are never returned
 // directly.
 var (
@@ -28,104 +35,540 @@ var (
 
 	// ErrCannotUnmapLocalhost indicates a request to unmap localhost.
 	ErrCannotUnmapLocalhost = errors.New("127: cannot unmap localhost")
+
+	// ErrCannotRemapLocalhost indicates a request to map localhost to another
+	// address, or to make it an alias of another hostname.
+	ErrCannotRemapLocalhost = errors.New("127: cannot remap localhost")
+
+	// ErrNotManaged indicates a request to modify a record that was not created
+	// by 127.
+	ErrNotManaged = hosts.ErrNotManaged
+
+	// ErrLocked indicates that the hosts file is locked by another process.
+	ErrLocked = hosts.ErrLocked
+
+	// ErrInvalidPool indicates an address pool outside of 127.0.0.0/8, or for
+	// IPv6, outside of fc00::/7.
+	ErrInvalidPool = errors.New("127: invalid address pool")
+
+	// ErrNoFreeAddress indicates that every address in the pool is assigned.
+	ErrNoFreeAddress = errors.New("127: no free address in pool")
+
+	// ErrInvalidIP indicates an IP address that is malformed, or that is
+	// neither a loopback address nor a unique local IPv6 address.
+	ErrInvalidIP = errors.New("127: invalid IP address")
+
+	// ErrNotMapped indicates a request to alias a hostname that is not mapped.
+	ErrNotMapped = errors.New("127: hostname not mapped")
+
+	// ErrIPInUse indicates a request to map a hostname to an IP address that is
+	// already mapped to another hostname.
+	ErrIPInUse = errors.New("127: IP address in use")
+
+	// ErrBackupNotFound indicates a request to restore a backup that does not
+	// exist.
+	ErrBackupNotFound = errors.New("127: backup not found")
 )
 
 // Hosts provide methods for mapping hostnames to random IP addresses. Its zero
 // value is usable and operates on the default hosts file.
+//
+// Records created by Hosts are kept in a managed block in the hosts file,
+// delimited by marker comments. Records outside the block are never modified,
+// unless forced.
 type Hosts struct {
-	file     *hosts.File
-	changed  bool
-	randFunc func(uint32) (uint32, error)
+	file        *hosts.File
+	filename    string
+	changed     bool
+	force       bool
+	allocator   Allocator
+	family      Family
+	pool, pool6 Pool
+	lockTimeout time.Duration
+	backupDir   string
+	backups     int
+	ttl         time.Duration
+	meta        *Meta
+	profile     string
+}
+
+// Family selects the IP versions of the addresses assigned to new mappings.
+type Family int
+
+const (
+	// IPv4 maps hostnames to IPv4 loopback addresses. This is the default.
+	IPv4 Family = 1 << iota
+
+	// IPv6 maps hostnames to IPv6 addresses. Without an IPv6 pool, hostnames are
+	// mapped to ::1.
+	IPv6
+
+	// DualStack maps hostnames to both an IPv4 and an IPv6 address.
+	DualStack = IPv4 | IPv6
+)
+
+// An Option configures Hosts.
+type Option func(*Hosts)
+
+// WithForce allows Hosts to modify and remove records outside the managed block.
+func WithForce(force bool) Option {
+	return func(h *Hosts) {
+		h.force = force
+	}
+}
+
+// WithAllocator sets the allocator picking the IP addresses of new mappings. The
+// default is a RandomAllocator.
+func WithAllocator(a Allocator) Option {
+	return func(h *Hosts) {
+		h.allocator = a
+	}
+}
+
+// WithFamily sets the IP versions of the addresses assigned to new mappings.
+func WithFamily(family Family) Option {
+	return func(h *Hosts) {
+		h.family = family
+	}
+}
+
+// WithPool sets the pool of addresses to allocate from, for the IP version of
+// the pool. The default IPv4 pool is 127.0.0.2-127.255.255.254, and the default
+// IPv6 pool only holds ::1. IPv6 pools should be bound to the loopback
+// interface. Pools can be parsed from CIDR notation with ParsePool.
+func WithPool(pool Pool) Option {
+	return func(h *Hosts) {
+		if pool.First.Is6() {
+			h.pool6 = pool
+		} else {
+			h.pool = pool
+		}
+	}
+}
+
+// WithLockTimeout sets the time to wait for other processes to release the
+// hosts file. A zero timeout fails immediately if the file is locked.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(h *Hosts) {
+		h.lockTimeout = timeout
+	}
+}
+
+// WithBackups makes Save back up the hosts file before changing it, keeping the
+// n most recent backups in dir. If dir is "", backups are kept next to the hosts
+// file, in a directory named after it with a ".backups" suffix. No backups are
+// made by default. Backups can be listed with Hosts.Backups, and restored with
+// Hosts.Restore.
+func WithBackups(dir string, n int) Option {
+	return func(h *Hosts) {
+		h.backupDir, h.backups = dir, n
+	}
+}
+
+// WithTTL makes mappings made by Map and MapTo expire ttl from now, after which
+// they are removed by Hosts.Sweep. The expiry is kept as metadata in a comment
+// of the record. Mappings never expire by default.
+func WithTTL(ttl time.Duration) Option {
+	return func(h *Hosts) {
+		h.ttl = ttl
+	}
 }
 
 // NewHosts opens a new Hosts using the given file. If filename is "" the
 // default hosts file is opened.
 //
-// Returned file system errors wrap *fs.PathError.
-func Open(filename string) (*Hosts, error) {
-	f, err := hosts.Open(filename)
+// The hosts file is locked against concurrent use by other processes until
+// Close is called. Returns an error matching ErrLocked if the lock can not be
+// acquired within the lock timeout.
+//
+// Returns an error matching ErrInvalidPool if a pool is invalid. Returned file
+// system errors wrap *fs.PathError. An error wrapping a *ParseError is returned
+// if the managed block is never ended, while other malformed lines are reported
+// by Warnings.
+func Open(filename string, opts ...Option) (*Hosts, error) {
+	h := &Hosts{
+		allocator:   RandomAllocator{},
+		family:      IPv4,
+		pool:        defaultPool(),
+		pool6:       localhostPool6(),
+		lockTimeout: DefaultLockTimeout,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if filename == "" {
+		filename = DefaultHostsFile
+	}
+	if h.backupDir == "" {
+		h.backupDir = filename + ".backups"
+	}
+
+	for _, pool := range []Pool{h.pool, h.pool6} {
+		if err := pool.validate(); err != nil {
+			return nil, fmt.Errorf("lib127: open: %w", err)
+		}
+	}
+
+	f, err := hosts.Open(filename, hosts.Config{
+		LockTimeout: h.lockTimeout, BackupDir: h.backupDir, Backups: h.backups,
+	})
 	if err != nil {
 		return nil, wrapError("open file", err)
 	}
-	return &Hosts{file: f}, nil
+	h.file, h.filename = f, filename
+
+	return h, nil
+}
+
+// ParseError describes a malformed line of the hosts file, giving its position
+// and the offending text.
+type ParseError = hosts.ParseError
+
+// Warnings returns the errors of the malformed lines of the hosts file, as it
+// was opened. Malformed lines are ignored, and kept as they are when saving.
+func (h *Hosts) Warnings() []*ParseError {
+	return h.file.Warnings()
+}
+
+// Mapping is a record from the hosts file, mapping an IP address to one or more
+// hostnames.
+type Mapping struct {
+	IP        string   `json:"ip"`
+	Hostnames []string `json:"hostnames"`
+	Comment   string   `json:"comment,omitempty"`
+	Line      int      `json:"line,omitempty"`
+	Managed   bool     `json:"managed"`
+
+	// Expires is the time the mapping expires, if made with a TTL.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// Profile is the profile of the mapping, if any, see WithProfile. Disabled
+	// is true if the mapping is switched off with its profile.
+	Profile  string `json:"profile,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+
+	Meta
+}
+
+// newMapping returns the mapping of a record.
+func newMapping(r hosts.Record) Mapping {
+	return Mapping{
+		IP:        r.IP,
+		Hostnames: r.Hostnames,
+		Comment:   r.Comment,
+		Line:      r.Line,
+		Managed:   r.Managed,
+		Expires:   parseTime(r.Meta[expiresKey]),
+		Profile:   r.Meta[profileKey],
+		Disabled:  r.Disabled,
+		Meta:      decodeMeta(r.Meta),
+	}
 }
 
+// Scope selects which records are returned by Hosts.Mappings.
+type Scope int
+
 const (
-	minIP uint32 = 2130706434 // 127.0.0.2
-	maxIP uint32 = 2147483646 // 127.255.255.254
+	// ScopeLoopback selects records with a loopback address, or an address in
+	// the IPv6 pool.
+	ScopeLoopback Scope = iota
+
+	// ScopeAll selects all records.
+	ScopeAll
 )
 
-// RandomIP returns a random unassigned loopback address.
+// Mappings returns the records within scope, in the order they appear in the
+// hosts file. Line is the line number of the record in the file as it was
+// opened, or 0 for records that have been added since.
+func (h *Hosts) Mappings(scope Scope) []Mapping {
+	var mappings []Mapping
+	for _, r := range h.file.Records() {
+		if scope == ScopeLoopback && !h.isLoopback(r.IP) {
+			continue
+		}
+
+		mappings = append(mappings, newMapping(r))
+	}
+	return mappings
+}
+
+// RandomIP returns a random unassigned loopback address from the pool. The
+// source of randomness of the allocator is used, if it is a RandomAllocator.
+// Returns an error matching ErrNoFreeAddress if the pool is exhausted.
 func (h *Hosts) RandomIP() (string, error) {
-	ip := make(net.IP, net.IPv4len)
+	a, _ := h.allocator.(RandomAllocator)
+	return h.allocate(a, "", h.pools()[0])
+}
 
-	for {
-		// Generate a random offset.
-		offset, err := h.randUint32(maxIP - minIP)
-		if err != nil {
-			return "", wrapError("generate random offset", err)
-		}
+// HashedIP returns the unassigned loopback address derived from a hash of the
+// hostname, as allocated by HashAllocator.
+//
+// Returned hostname errors can be matched against ErrHostnameInvalid and
+// ErrHostnameIsIP.
+func (h *Hosts) HashedIP(hostname string) (string, error) {
+	return h.allocate(HashAllocator{}, hostname, h.pools()[0])
+}
 
-		// Add random offset and convert integer to IP address.
-		binary.BigEndian.PutUint32(ip, minIP+offset)
-		if h.file.HasIP(ip.String()) {
-			continue
-		}
+// pools returns the pools of the families of new mappings, IPv4 first.
+func (h *Hosts) pools() []Pool {
+	switch h.family {
+	case IPv6:
+		return []Pool{h.pool6}
+	case DualStack:
+		return []Pool{h.pool, h.pool6}
+	default:
+		return []Pool{h.pool}
+	}
+}
+
+// allocate returns an unassigned address from pool allocated by a.
+func (h *Hosts) allocate(a Allocator, hostname string, pool Pool) (string, error) {
+	// The IPv6 loopback address is shared, since there is only one.
+	if pool == localhostPool6() {
+		return pool.First.String(), nil
+	}
 
-		return ip.String(), nil
+	addr, err := a.Allocate(hostname, pool, func(addr netip.Addr) bool {
+		return addr == localhostAddr() || h.file.HasIP(addr.String())
+	})
+	if err != nil {
+		return "", wrapError("allocate IP", err)
 	}
+	return addr.String(), nil
 }
 
 // IP returns the IP address associated with the specified hostname. Returns an
-// empty string if hostname were not found.
+// empty string if hostname were not found. Only the first address is returned
+// for a hostname mapped to several, such as a dual-stack hostname, preferring
+// the family of new mappings. Use IPs to get every address.
 //
-// Returned hostname errors can be matched against ErrInvalidHostname and
+// Returned hostname errors can be matched against ErrHostnameInvalid and
 // ErrHostnameIsIP.
 func (h *Hosts) IP(hostname string) (string, error) {
+	ips, err := h.IPs(hostname)
+	if err != nil {
+		return "", err
+	}
+	return h.preferredIP(ips), nil
+}
+
+// preferredIP returns the first of ips of the family of new mappings, or else
+// the first of ips. Returns an empty string if ips is empty.
+func (h *Hosts) preferredIP(ips []string) string {
+	for _, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil && addr.Is6() == (h.family == IPv6) {
+			return ip
+		}
+	}
+	if len(ips) == 0 {
+		return ""
+	}
+	return ips[0]
+}
+
+// IPs returns every IP address associated with the specified hostname, in the
+// order they appear in the hosts file.
+//
+// Returned hostname errors can be matched against ErrHostnameInvalid and
+// ErrHostnameIsIP.
+func (h *Hosts) IPs(hostname string) ([]string, error) {
 	if isLocalhost(hostname) {
-		return "127.0.0.1", nil
+		return []string{localhostAddr().String(), netip.IPv6Loopback().String()}, nil
 	}
 
-	ip, err := h.file.IP(hostname)
+	ips, err := h.file.IPs(hostname)
 	if err != nil {
-		return "", wrapError("get IP", err)
+		return nil, wrapError("get IP", err)
 	}
-	return ip, nil
+	return ips, nil
 }
 
-// Map maps the specified hostname to a random unnasigned loopback address, and
-// returns that IP. If the hostname is already mapped, we return the already
-// assigned IP address instead.
+// Map maps the specified hostname to an unnasigned loopback address picked by
+// the allocator, and returns that IP. If the hostname is already mapped, we
+// return the already assigned IP address instead. Dual-stack hostnames are
+// mapped to both an IPv4 and an IPv6 address, and the IPv4 address is returned.
+// Only the missing address is added to a hostname mapped to just one of them.
 //
-// Returned hostname errors can be matched against ErrInvalidHostname and
+// Returned hostname errors can be matched against ErrHostnameInvalid and
 // ErrHostnameIsIP.
 func (h *Hosts) Map(hostname string) (string, error) {
+	ips, err := h.IPs(hostname)
+	if err != nil || (len(ips) > 0 && h.family != DualStack) {
+		return h.preferredIP(ips), err
+	}
+
+	for _, pool := range h.pools() {
+		if slices.ContainsFunc(ips, func(ip string) bool {
+			return isIPv4(ip) == pool.First.Is4()
+		}) {
+			continue
+		}
+
+		ip, err := h.allocate(h.allocator, hostname, pool)
+		if err != nil {
+			return "", err
+		}
+
+		if err = h.file.Map(hostname, ip); err != nil {
+			return "", wrapError("set hostname", err)
+		}
+		if err = h.annotate(hostname, ip); err != nil {
+			return "", err
+		}
+		h.changed = true
+		ips = append(ips, ip)
+	}
+
+	return h.preferredIP(ips), nil
+}
+
+// MapTo maps the specified hostname to the given loopback address, replacing
+// any mapping of the hostname to another address of the same IP version. Unique
+// local IPv6 addresses are accepted as well. Returns the replaced mappings,
+// including other hostnames previously mapped to the IP when forced.
+//
+// Returned hostname errors can be matched against ErrHostnameInvalid and
+// ErrHostnameIsIP. Returns an error matching ErrInvalidIP if the IP address is
+// not valid, and ErrIPInUse if it is mapped to another hostname, or reserved by
+// a profile that is switched off, unless forced. The addresses of localhost may
+// be shared by any number of hostnames. Returns an error matching ErrNotManaged
+// if a replaced mapping is outside the managed block, unless forced, and
+// ErrCannotRemapLocalhost if hostname is localhost.
+func (h *Hosts) MapTo(hostname, ip string) ([]Mapping, error) {
 	if isLocalhost(hostname) {
-		return "127.0.0.1", nil
+		return nil, ErrCannotRemapLocalhost
 	}
 
-	if ip, err := h.IP(hostname); ip != "" || err != nil {
-		return ip, err
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !(addr.Is4() && loopbackPrefix().Contains(addr) ||
+		addr == netip.IPv6Loopback() || ulaPrefix().Contains(addr)) {
+		return nil, fmt.Errorf("lib127: map to %s: %w", ip, ErrInvalidIP)
 	}
 
-	ip, err := h.RandomIP()
+	name, err := hosts.AdaptHostname(hostname)
 	if err != nil {
-		return "", err
+		return nil, wrapError("map to", err)
 	}
 
-	if err = h.file.Map(hostname, ip); err != nil {
-		return "", wrapError("set hostname", err)
+	// Find the mappings of the hostname to another address of the same IP
+	// version, and of other hostnames to the address, including those that are
+	// switched off, as they keep the address reserved.
+	var replaced []Mapping
+	for _, r := range h.allRecords() {
+		rAddr, err := netip.ParseAddr(r.IP)
+		if err != nil {
+			continue
+		}
+
+		var names []string
+		switch {
+		case rAddr != addr && r.Disabled:
+		case rAddr == addr:
+			if !r.Disabled && slices.Contains(r.Hostnames, name) {
+				return nil, nil
+			}
+			if addr != localhostAddr() && addr != netip.IPv6Loopback() {
+				names = r.Hostnames
+			}
+		case rAddr.Is4() == addr.Is4() && slices.Contains(r.Hostnames, name):
+			names = []string{name}
+		}
+
+		if len(names) > 0 {
+			m := newMapping(r)
+			m.Hostnames = names
+			replaced = append(replaced, m)
+		}
+	}
+
+	for _, m := range replaced {
+		if m.IP == addr.String() && !h.force {
+			return nil, fmt.Errorf("lib127: map to %s: %w", ip, ErrIPInUse)
+		}
+		if !m.Managed && !h.force {
+			return nil, fmt.Errorf("lib127: map to %s: %q: %w", ip, m.Hostnames[0], ErrNotManaged)
+		}
+	}
+
+	for _, m := range replaced {
+		for _, name := range m.Hostnames {
+			if err := h.file.UnmapIP(name, m.IP, h.force); err != nil {
+				return nil, wrapError("map to", err)
+			}
+		}
+	}
+	if err := h.file.Map(hostname, addr.String()); err != nil {
+		return nil, wrapError("set hostname", err)
+	}
+	if err := h.annotate(hostname, addr.String()); err != nil {
+		return nil, err
 	}
 	h.changed = true
 
-	return ip, nil
+	return replaced, nil
 }
 
-// Unmap unmaps the specified hostname and returns the associated IP. Returns
-// an empty string if hostname were not found.
+// Alias adds alias to the records of the specified hostname, so that both names
+// share the same addresses, and returns the IP of the hostname, as returned by
+// IP. Other mappings of alias are replaced. Unmapping either name later keeps
+// the addresses mapped to the other.
 //
-// Returned hostname errors can be matched against ErrInvalidHostname and
-// ErrHostnameIsIP.
+// Returned hostname errors can be matched against ErrHostnameInvalid and
+// ErrHostnameIsIP. Returns an error matching ErrNotMapped if hostname is not
+// mapped, and ErrNotManaged if a modified record is outside the managed block,
+// unless forced. Returns an error matching ErrCannotRemapLocalhost if alias is
+// localhost.
+func (h *Hosts) Alias(hostname, alias string) (string, error) {
+	if isLocalhost(alias) {
+		return "", ErrCannotRemapLocalhost
+	}
+
+	ips, err := h.file.IPs(hostname)
+	if err != nil {
+		return "", wrapError("alias", err)
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("lib127: alias %q: %w", hostname, ErrNotMapped)
+	}
+
+	name, err := hosts.AdaptHostname(alias)
+	if err != nil {
+		return "", wrapError("alias", err)
+	}
+
+	// Find the other mappings of alias, which are replaced once the alias is
+	// added.
+	var replaced []hosts.Record
+	for _, r := range h.file.Records() {
+		if slices.Contains(r.Hostnames, name) && !slices.Contains(ips, r.IP) {
+			if !r.Managed && !h.force {
+				return "", fmt.Errorf("lib127: alias %q: %w", alias, ErrNotManaged)
+			}
+			replaced = append(replaced, r)
+		}
+	}
+
+	if err := h.file.Alias(hostname, alias, h.force); err != nil {
+		return "", wrapError("alias", err)
+	}
+	for _, r := range replaced {
+		if err := h.file.UnmapIP(alias, r.IP, h.force); err != nil {
+			return "", wrapError("alias", err)
+		}
+	}
+	h.changed = true
+
+	return h.IP(hostname)
+}
+
+// Unmap unmaps the specified hostname and returns the associated IP, as
+// returned by IP. Every address of the hostname is unmapped, including those of
+// profiles that are switched off, whose IP is returned if the hostname is not
+// otherwise mapped. Returns an empty string if hostname were not found.
+//
+// Returned hostname errors can be matched against ErrHostnameInvalid and
+// ErrHostnameIsIP. Returns an error matching ErrNotManaged if the hostname is
+// mapped outside the managed block, unless forced.
 func (h *Hosts) Unmap(hostname string) (string, error) {
 	if isLocalhost(hostname) {
 		return "", ErrCannotUnmapLocalhost
@@ -135,8 +578,11 @@ func (h *Hosts) Unmap(hostname string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if ip == "" {
+		ip = h.disabledIP(hostname)
+	}
 
-	if err = h.file.Unmap(hostname); err != nil {
+	if err = h.file.Unmap(hostname, h.force); err != nil {
 		return "", wrapError("set hostname", err)
 	}
 	h.changed = true
@@ -144,6 +590,206 @@ func (h *Hosts) Unmap(hostname string) (string, error) {
 	return ip, nil
 }
 
+// IsWildcard reports whether hostname is a wildcard pattern, such as
+// "*.app.test", that matches every subdomain of a domain.
+func IsWildcard(hostname string) bool {
+	return strings.HasPrefix(hostname, "*.")
+}
+
+// MapWildcard maps every subdomain matching the given pattern, such as
+// "*.app.test", and returns the IP, as for Map. If the domain of the pattern
+// is mapped, its addresses are used, so that the subdomains resolve to the same
+// host. Otherwise new addresses are allocated.
+//
+// Hosts files can not express wildcards, so they are kept as comments in the
+// managed block, and only resolved by Resolve and the DNS server of package
+// dns.
+//
+// Returns an error matching ErrHostnameInvalid if the pattern is invalid.
+func (h *Hosts) MapWildcard(pattern string) (string, error) {
+	ips, err := h.wildcardIPs(pattern)
+	if ip := h.preferredIP(ips); ip != "" || err != nil {
+		return ip, err
+	}
+
+	domain := strings.TrimPrefix(pattern, "*.")
+	if ips, err = h.file.IPs(domain); err != nil {
+		return "", wrapError("map wildcard", err)
+	}
+	if len(ips) == 0 {
+		for _, pool := range h.pools() {
+			ip, err := h.allocate(h.allocator, domain, pool)
+			if err != nil {
+				return "", err
+			}
+			ips = append(ips, ip)
+		}
+	}
+
+	for _, ip := range ips {
+		if err := h.file.MapWildcard(pattern, ip); err != nil {
+			return "", wrapError("map wildcard", err)
+		}
+	}
+	h.changed = true
+
+	return h.preferredIP(ips), nil
+}
+
+// UnmapWildcard unmaps the given pattern and returns the associated IP, as
+// returned by MapWildcard. Returns an empty string if the pattern were not
+// found.
+//
+// Returns an error matching ErrHostnameInvalid if the pattern is invalid.
+func (h *Hosts) UnmapWildcard(pattern string) (string, error) {
+	ips, err := h.wildcardIPs(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	if err := h.file.UnmapWildcard(pattern); err != nil {
+		return "", wrapError("unmap wildcard", err)
+	}
+	h.changed = true
+
+	return h.preferredIP(ips), nil
+}
+
+// wildcardIPs returns the IP addresses mapped to pattern.
+func (h *Hosts) wildcardIPs(pattern string) ([]string, error) {
+	adaptedPattern, err := hosts.AdaptPattern(pattern)
+	if err != nil {
+		return nil, wrapError("get wildcard", err)
+	}
+
+	var ips []string
+	for _, w := range h.file.Wildcards() {
+		if w.Pattern == adaptedPattern {
+			ips = append(ips, w.IP)
+		}
+	}
+	return ips, nil
+}
+
+// Wildcards returns the wildcard patterns within scope, in the order they were
+// mapped. Each pattern is mapped to a single IP address.
+func (h *Hosts) Wildcards(scope Scope) []Mapping {
+	var mappings []Mapping
+	for _, w := range h.file.Wildcards() {
+		if scope == ScopeLoopback && !h.isLoopback(w.IP) {
+			continue
+		}
+		mappings = append(mappings, Mapping{
+			IP: w.IP, Hostnames: []string{w.Pattern}, Managed: true,
+		})
+	}
+	return mappings
+}
+
+// Resolve returns the IP addresses of the specified hostname, as returned by
+// IPs. Hostnames that are not mapped are resolved by the most specific wildcard
+// pattern matching them, if any.
+//
+// Returned hostname errors can be matched against ErrHostnameInvalid and
+// ErrHostnameIsIP.
+func (h *Hosts) Resolve(hostname string) ([]string, error) {
+	ips, err := h.IPs(hostname)
+	if err != nil || len(ips) > 0 {
+		return ips, err
+	}
+
+	// IPs has validated the hostname already.
+	name, _ := hosts.AdaptHostname(hostname)
+
+	var pattern string
+	for _, w := range h.file.Wildcards() {
+		domain := strings.TrimPrefix(w.Pattern, "*")
+		if strings.HasSuffix(name, domain) && len(w.Pattern) > len(pattern) {
+			pattern = w.Pattern
+		}
+	}
+
+	if pattern == "" {
+		return nil, nil
+	}
+	return h.wildcardIPs(pattern)
+}
+
+// Update calls fn with a transaction for mapping and unmapping several
+// hostnames at once. If fn returns nil and every operation of the transaction
+// succeeded, all changes are saved to disk at once. Otherwise every change made
+// by the transaction is rolled back, and the first error is returned.
+//
+// Hostnames mapped within a transaction are assigned distinct IP addresses.
+func (h *Hosts) Update(fn func(tx *Tx) error) error {
+	file, err := h.file.Clone()
+	if err != nil {
+		return wrapError("begin transaction", err)
+	}
+	changed := h.changed
+
+	tx := &Tx{hosts: h}
+	if err = fn(tx); tx.err != nil {
+		err = tx.err
+	}
+	if err == nil {
+		err = h.Save()
+	}
+
+	if err != nil {
+		h.file, h.changed = file, changed
+		return err
+	}
+	return nil
+}
+
+// Tx is a transaction passed to the function given to Hosts.Update. It must not
+// be used after that function returns.
+type Tx struct {
+	hosts *Hosts
+	err   error
+}
+
+// IP returns the IP address associated with the specified hostname, as for
+// Hosts.IP.
+func (tx *Tx) IP(hostname string) (string, error) {
+	return tx.hosts.IP(hostname)
+}
+
+// Map maps the specified hostname to a random unassigned loopback address, as
+// for Hosts.Map. The transaction is rolled back if an error is returned.
+func (tx *Tx) Map(hostname string) (string, error) {
+	return tx.track(tx.hosts.Map(hostname))
+}
+
+// MapTo maps the specified hostname to the given IP address, as for
+// Hosts.MapTo. The transaction is rolled back if an error is returned.
+func (tx *Tx) MapTo(hostname, ip string) ([]Mapping, error) {
+	replaced, err := tx.hosts.MapTo(hostname, ip)
+	_, _ = tx.track("", err)
+	return replaced, err
+}
+
+// Alias adds alias to the records of the specified hostname, as for
+// Hosts.Alias. The transaction is rolled back if an error is returned.
+func (tx *Tx) Alias(hostname, alias string) (string, error) {
+	return tx.track(tx.hosts.Alias(hostname, alias))
+}
+
+// Unmap unmaps the specified hostname, as for Hosts.Unmap. The transaction is
+// rolled back if an error is returned.
+func (tx *Tx) Unmap(hostname string) (string, error) {
+	return tx.track(tx.hosts.Unmap(hostname))
+}
+
+// track records the first error of the transaction.
+func (tx *Tx) track(ip string, err error) (string, error) {
+	if err != nil && tx.err == nil {
+		tx.err = err
+	}
+	return ip, err
+}
+
 // Save saves the modified hosts file to disk. Does nothing if no changes were
 // made.
 //
@@ -159,25 +805,74 @@ func (h *Hosts) Save() error {
 	return nil
 }
 
-func (h *Hosts) randUint32(max uint32) (uint32, error) {
-	if h.randFunc == nil {
-		return defaultRandFunc(max)
+// Diff returns the changes that Save would make to the hosts file, as a unified
+// diff against its content when opened. Returns an empty string if no changes
+// were made. Use Diff instead of Save for a dry run.
+func (h *Hosts) Diff() string {
+	if !h.changed {
+		return ""
 	}
-	return h.randFunc(max)
+	return h.file.Diff()
+}
+
+// Backup is a copy of the hosts file, made by Save before changing it.
+type Backup struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
 }
 
-// defaultRandFunc is a cryptographically secure random number generator.
-func defaultRandFunc(max uint32) (uint32, error) {
-	bigInt, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+// Backups returns the backups of the hosts file, newest first. Backups are only
+// made if enabled with WithBackups, but are listed from the default directory
+// otherwise.
+//
+// Returned file system errors wrap *fs.PathError.
+func (h *Hosts) Backups() ([]Backup, error) {
+	backups, err := hosts.Backups(h.backupDir, h.filename)
 	if err != nil {
-		return 0, wrapError("random int", err)
+		return nil, wrapError("list backups", err)
+	}
+
+	var bs []Backup
+	for _, b := range backups {
+		bs = append(bs, Backup(b))
 	}
-	return uint32(bigInt.Int64()), nil
+	return bs, nil
+}
+
+// Restore replaces the content of the hosts file with that of the named
+// backup, as listed by Backups. Saving backs up the replaced content, so that a
+// restore can be undone as well. Returns an error matching ErrBackupNotFound if
+// there is no such backup.
+func (h *Hosts) Restore(name string) error {
+	content, err := hosts.ReadBackup(h.backupDir, h.filename, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("lib127: restore %q: %w", name, ErrBackupNotFound)
+	}
+	if err != nil {
+		return wrapError("restore", err)
+	}
+
+	if err := h.file.Restore(content); err != nil {
+		return wrapError("restore", err)
+	}
+	h.changed = true
+	return nil
+}
+
+// Close releases the lock on the hosts file. Changes that have not been saved
+// are discarded.
+func (h *Hosts) Close() error {
+	if err := h.file.Close(); err != nil {
+		return wrapError("close", err)
+	}
+	return nil
 }
 
 func wrapError(msg string, err error) error {
 	// Wrap recognized errors to make them available to the caller.
-	if errors.As(err, new(*fs.PathError)) || errors.Is(err, ErrHostnameInvalid) {
+	if errors.As(err, new(*fs.PathError)) || errors.As(err, new(*ParseError)) ||
+		errors.Is(err, ErrHostnameInvalid) || errors.Is(err, ErrNotManaged) ||
+		errors.Is(err, ErrLocked) || errors.Is(err, ErrNoFreeAddress) {
 		return fmt.Errorf("lib127: %s: %w", msg, err)
 	}
 
@@ -188,3 +883,8 @@ func wrapError(msg string, err error) error {
 func isLocalhost(hostname string) bool {
 	return hostname == "localhost" || hostname == "localhost.localdomain"
 }
+
+func (h *Hosts) isLoopback(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && (addr.IsLoopback() || h.pool6.Contains(addr))
+}
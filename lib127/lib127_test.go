@@ -2,10 +2,16 @@ package lib127_test
 
 import (
 	"errors"
+	"fmt"
 	"io/fs"
 	"math/rand"
+	"net/netip"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/lende/127/internal/testdata"
 	"github.com/lende/127/lib127"
@@ -33,6 +39,11 @@ func TestOperations(t *testing.T) {
 	// Pre-assigned public domain.
 	call(h.IP("example.com")).assertIP(t, "93.184.216.34")
 
+	// Hostname mapped outside the managed block.
+	call(h.Map("unmanaged.test")).assertIP(t, "127.0.0.4")
+	call(h.Unmap("unmanaged.test")).assertErrorIs(t, lib127.ErrNotManaged)
+	call(h.IP("unmanaged.test")).assertIP(t, "127.0.0.4")
+
 	// Commented out hostname.
 	call(h.IP("private.test")).assertIP(t, "")
 
@@ -52,6 +63,685 @@ func TestOperations(t *testing.T) {
 	call(h.Unmap("localhost")).assertErrorIs(t, lib127.ErrCannotUnmapLocalhost)
 }
 
+func TestMappings(t *testing.T) {
+	t.Parallel()
+
+	h := openHosts(t)
+	call(h.Map("new.test")).assertIP(t, pseudoRndIP1)
+
+	loopback := []lib127.Mapping{
+		{IP: "127.0.0.1", Hostnames: []string{"localhost", "localhost.localdomain"}, Line: 1},
+		{IP: "127.0.0.4", Hostnames: []string{"unmanaged.test"}, Line: 2},
+		{IP: "127.0.0.3", Hostnames: []string{"loopback.test"}, Line: 10, Managed: true},
+		{IP: pseudoRndIP1, Hostnames: []string{"new.test"}, Managed: true},
+	}
+	if got := h.Mappings(lib127.ScopeLoopback); !reflect.DeepEqual(got, loopback) {
+		t.Errorf("want loopback mappings: %v, got: %v", loopback, got)
+	}
+
+	all := []lib127.Mapping{
+		loopback[0], loopback[1],
+		{IP: "93.184.216.34", Hostnames: []string{"example.com"}, Comment: "Public address.", Line: 7},
+		loopback[2], loopback[3],
+	}
+	if got := h.Mappings(lib127.ScopeAll); !reflect.DeepEqual(got, all) {
+		t.Errorf("want all mappings: %v, got: %v", all, got)
+	}
+}
+
+func TestSave(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name, input, want string
+	}{
+		{
+			name:  "managed block",
+			input: "127.0.0.3  foo.test\n# BEGIN 127 MANAGED BLOCK\n# END 127 MANAGED BLOCK\n\n",
+			want: "127.0.0.3  foo.test\n# BEGIN 127 MANAGED BLOCK\n" + pseudoRndIP1 +
+				" new.test\n# END 127 MANAGED BLOCK\n\n",
+		},
+		{
+			name:  "no managed block",
+			input: "127.0.0.3  foo.test # Comment.",
+			want: "127.0.0.3  foo.test # Comment.\n# BEGIN 127 MANAGED BLOCK\n" + pseudoRndIP1 +
+				" new.test\n# END 127 MANAGED BLOCK\n",
+		},
+	} {
+		path := filepath.Join(t.TempDir(), "hosts")
+		requireNoError(t, os.WriteFile(path, []byte(test.input), 0o600))
+
+		h := openHostsFile(t, path)
+		call(h.Map("new.test")).assertIP(t, pseudoRndIP1)
+		requireNoError(t, h.Save())
+
+		b, err := os.ReadFile(path)
+		requireNoError(t, err)
+		if got := string(b); got != test.want {
+			t.Errorf("%s: want content: %q, got: %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestSaveReplace(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	target, link := filepath.Join(dir, "hosts.real"), filepath.Join(dir, "hosts")
+	requireNoError(t, os.WriteFile(target, []byte("127.0.0.1 localhost\n"), 0o640))
+	requireNoError(t, os.Symlink(target, link))
+
+	h := openHostsFile(t, link)
+	call(h.Map("new.test")).assertIP(t, pseudoRndIP1)
+	requireNoError(t, h.Save())
+	requireNoError(t, h.Close())
+
+	// The symlink should be kept and the target replaced with the same mode.
+	if info, err := os.Lstat(link); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("want symlink, got: %v, %v", info, err)
+	}
+	if info, err := os.Stat(target); err != nil || info.Mode().Perm() != 0o640 {
+		t.Errorf("want mode: %v, got: %v, %v", fs.FileMode(0o640), info, err)
+	}
+
+	// No temporary files should be left behind, only the lock file.
+	entries, err := os.ReadDir(dir)
+	requireNoError(t, err)
+	if len(entries) != 3 {
+		t.Errorf("want 3 directory entries, got: %v", entries)
+	}
+
+	call(openHostsFile(t, link).IP("new.test")).assertIP(t, pseudoRndIP1)
+}
+
+func TestForce(t *testing.T) {
+	t.Parallel()
+
+	path := testdata.HostsFile(t)
+	h := openHostsFile(t, path, lib127.WithForce(true))
+	call(h.Unmap("unmanaged.test")).assertIP(t, "127.0.0.4")
+	call(h.Unmap("loopback.test")).assertIP(t, "127.0.0.3")
+	requireNoError(t, h.Save())
+	requireNoError(t, h.Close())
+
+	h = openHostsFile(t, path)
+	call(h.IP("unmanaged.test")).assertIP(t, "")
+	call(h.IP("loopback.test")).assertIP(t, "")
+	call(h.IP("example.com")).assertIP(t, "93.184.216.34")
+}
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	path := testdata.HostsFile(t)
+	h := openHostsFile(t, path)
+	if d := h.Diff(); d != "" {
+		t.Errorf("want no diff, got: %s", d)
+	}
+
+	call(h.Map("new.test")).assertIP(t, pseudoRndIP1)
+	call(h.Unmap("loopback.test")).assertIP(t, "127.0.0.3")
+	want := "--- " + path + "\n+++ " + path + "\n@@ -7,5 +7,5 @@\n" +
+		" 93.184.216.34 example.com # Public address.\n \n # BEGIN 127 MANAGED BLOCK\n" +
+		"-127.0.0.3 loopback.test\n+" + pseudoRndIP1 + " new.test\n # END 127 MANAGED BLOCK\n"
+	if d := h.Diff(); d != want {
+		t.Errorf("want diff:\n%s\ngot:\n%s", want, d)
+	}
+
+	// The diff is not saved.
+	b, err := os.ReadFile(path)
+	requireNoError(t, err)
+	if strings.Contains(string(b), "new.test") {
+		t.Errorf("want unchanged file, got: %s", b)
+	}
+
+	requireNoError(t, h.Save())
+	if d := h.Diff(); d != "" {
+		t.Errorf("want no diff after save, got: %s", d)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	t.Parallel()
+
+	h := openHostsFile(t, testdata.HostsFile(t), lib127.WithTTL(time.Hour),
+		lib127.WithFamily(lib127.DualStack))
+	call(h.Map("temporary.test")).assertIP(t, pseudoRndIP1)
+	_, err := h.MapTo("fixed.test", "127.0.0.9")
+	requireNoError(t, err)
+
+	var expires []time.Time
+	for _, m := range h.Mappings(lib127.ScopeLoopback) {
+		if m.Expires != nil {
+			expires = append(expires, *m.Expires)
+		}
+	}
+	if len(expires) != 3 || time.Until(expires[0]) <= 59*time.Minute {
+		t.Fatalf("Want 3 mappings expiring in an hour, got: %v", expires)
+	}
+
+	if expired := h.Sweep(time.Now()); len(expired) > 0 {
+		t.Errorf("Want no expired mappings, got: %v", expired)
+	}
+
+	expired := h.Sweep(expires[0])
+	var names []string
+	for _, m := range expired {
+		names = append(names, m.IP+" "+strings.Join(m.Hostnames, " "))
+	}
+	if want := []string{pseudoRndIP1 + " temporary.test", "::1 temporary.test",
+		"127.0.0.9 fixed.test"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Want expired mappings: %q, got: %q", want, names)
+	}
+	call(h.IP("temporary.test")).assertIP(t, "")
+	call(h.IP("loopback.test")).assertIP(t, "127.0.0.3")
+
+	// Mappings of profiles that are switched off expire as well.
+	h = openHostsFile(t, testdata.HostsFile(t), lib127.WithTTL(time.Hour),
+		lib127.WithProfile("ci"))
+	call(h.Map("ci.test")).assertIP(t, pseudoRndIP1)
+	h.DisableProfile("ci")
+	expired = h.Sweep(time.Now().Add(2 * time.Hour))
+	if len(expired) != 1 || !expired[0].Disabled || len(h.Profiles()) > 0 {
+		t.Errorf("Want the switched off mapping expired, got: %v", expired)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	t.Parallel()
+
+	created := time.Date(2026, 10, 16, 9, 15, 2, 0, time.UTC)
+	path := testdata.HostsFile(t)
+	h := openHostsFile(t, path, lib127.WithTTL(time.Hour),
+		lib127.WithMeta(lib127.Meta{User: "alice", Tag: "shop", Note: "billing service"}))
+	call(h.Map("billing.test")).assertIP(t, pseudoRndIP1)
+	_, err := h.MapTo("pay.test", "127.0.0.9")
+	requireNoError(t, err)
+
+	mappings := h.Mappings(lib127.ScopeLoopback)
+	for _, m := range mappings[len(mappings)-2:] {
+		if m.User != "alice" || m.Tag != "shop" || m.Note != "billing service" ||
+			m.Created == nil || time.Since(*m.Created) > time.Minute || m.Expires == nil {
+			t.Errorf("Unexpected metadata: %+v", m)
+		}
+	}
+
+	requireNoError(t, h.SetMeta("billing.test", lib127.Meta{User: "bob", Created: &created}))
+	output{err: h.SetMeta("unmanaged.test", lib127.Meta{})}.assertErrorIs(t, lib127.ErrNotManaged)
+	output{err: h.SetMeta("missing.test", lib127.Meta{})}.assertErrorIs(t, lib127.ErrNotMapped)
+	requireNoError(t, h.Save())
+
+	b, err := os.ReadFile(filepath.Clean(path))
+	requireNoError(t, err)
+	want := pseudoRndIP1 + " billing.test # 127: created=2026-10-16T09:15:02Z expires="
+	if !strings.Contains(string(b), want) || !strings.Contains(string(b), "user=bob\n") {
+		t.Errorf("Want hosts file with %q, got: %q", want, b)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	t.Parallel()
+
+	path := testdata.HostsFile(t)
+	h := openHostsFile(t, path, lib127.WithProfile("shop"))
+	for i, name := range []string{"shop.test", "cart.shop.test"} {
+		_, err := h.MapTo(name, fmt.Sprintf("127.0.0.%d", 5+i))
+		requireNoError(t, err)
+	}
+	requireNoError(t, h.Save())
+	requireNoError(t, h.Close())
+
+	h = openHostsFile(t, path, lib127.WithProfile("blog"))
+	call(h.Map("blog.test")).assertIP(t, pseudoRndIP1)
+	if got, want := h.Profiles(), []string{"blog", "shop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want profiles: %v, got: %v", want, got)
+	}
+
+	assertMappings := func(got []lib127.Mapping, want ...string) {
+		t.Helper()
+
+		var lines []string
+		for _, m := range got {
+			line := m.IP + " " + strings.Join(m.Hostnames, " ") + " " + m.Profile
+			if m.Disabled {
+				line += " off"
+			}
+			lines = append(lines, line)
+		}
+		if !reflect.DeepEqual(lines, want) {
+			t.Errorf("Want mappings: %q, got: %q", want, lines)
+		}
+	}
+	assertMappings(h.DisableProfile("shop"),
+		"127.0.0.5 shop.test shop", "127.0.0.6 cart.shop.test shop")
+	call(h.IP("shop.test")).assertIP(t, "")
+	_, err := h.MapTo("other.test", "127.0.0.5")
+	output{err: err}.assertErrorIs(t, lib127.ErrIPInUse)
+	assertMappings(h.ProfileMappings("shop"),
+		"127.0.0.5 shop.test shop off", "127.0.0.6 cart.shop.test shop off")
+	if got, want := h.ExportProfile("shop"), "# 127.0.0.5 shop.test # 127: profile=shop\n"+
+		"# 127.0.0.6 cart.shop.test # 127: profile=shop\n"; got != want {
+		t.Errorf("Want export: %q, got: %q", want, got)
+	}
+
+	assertMappings(h.EnableProfile("shop"),
+		"127.0.0.5 shop.test shop off", "127.0.0.6 cart.shop.test shop off")
+	call(h.IP("shop.test")).assertIP(t, "127.0.0.5")
+	assertMappings(h.UnmapProfile("shop"),
+		"127.0.0.5 shop.test shop", "127.0.0.6 cart.shop.test shop")
+	assertMappings(h.UnmapProfile("missing"))
+	assertMappings(h.UnmapProfile(""))
+
+	call(h.IP("cart.shop.test")).assertIP(t, "")
+	call(h.IP("blog.test")).assertIP(t, pseudoRndIP1)
+	call(h.IP("loopback.test")).assertIP(t, "127.0.0.3")
+	if got, want := h.Profiles(), []string{"blog"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want profiles: %v, got: %v", want, got)
+	}
+
+	// Hostnames of profiles that are switched off can be unmapped as well.
+	assertMappings(h.DisableProfile("blog"), pseudoRndIP1+" blog.test blog")
+	call(h.Unmap("blog.test")).assertIP(t, pseudoRndIP1)
+	call(h.Unmap("blog.test")).assertIP(t, "")
+	if got := h.Profiles(); len(got) > 0 {
+		t.Errorf("Want no profiles, got: %v", got)
+	}
+}
+
+func TestBackups(t *testing.T) {
+	t.Parallel()
+
+	path := testdata.HostsFile(t)
+	dir := filepath.Join(t.TempDir(), "backups")
+	h := openHostsFile(t, path, lib127.WithBackups(dir, 2))
+
+	// Record the content before each of three saves.
+	var contents []string
+	for _, hostname := range []string{"one.test", "two.test", "three.test"} {
+		b, err := os.ReadFile(path)
+		requireNoError(t, err)
+		contents = append(contents, string(b))
+
+		_, err = h.Map(hostname)
+		requireNoError(t, err)
+		requireNoError(t, h.Save())
+	}
+
+	backups, err := h.Backups()
+	requireNoError(t, err)
+	if len(backups) != 2 || !backups[0].Time.After(backups[1].Time) {
+		t.Fatalf("want the 2 newest backups, newest first, got: %v", backups)
+	}
+
+	requireNoError(t, h.Restore(backups[0].Name))
+	requireNoError(t, h.Save())
+	if b, err := os.ReadFile(path); err != nil || string(b) != contents[2] {
+		t.Errorf("want content of newest backup: %q, got: %q (%v)", contents[2], b, err)
+	}
+	call(h.IP("three.test")).assertIP(t, "")
+
+	// Restoring is backed up as well.
+	restored, err := h.Backups()
+	requireNoError(t, err)
+	if len(restored) != 2 || restored[1] != backups[0] {
+		t.Errorf("want new backup before %v, got: %v", backups[0], restored)
+	}
+
+	for _, name := range []string{"missing", "../" + filepath.Base(path), ""} {
+		if err := h.Restore(name); !errors.Is(err, lib127.ErrBackupNotFound) {
+			t.Errorf("%q: want error matching ErrBackupNotFound, got: %v", name, err)
+		}
+	}
+}
+
+func TestHashedIP(t *testing.T) {
+	t.Parallel()
+
+	const hashedIP = "127.104.254.134"
+	h := openHostsFile(t, testdata.HostsFile(t), lib127.WithAllocator(lib127.HashAllocator{}))
+	call(h.HashedIP("hashed.test")).assertIP(t, hashedIP)
+	call(h.HashedIP("HASHED.test")).assertIP(t, hashedIP)
+	call(h.Map("hashed.test")).assertIP(t, hashedIP)
+	call(h.HashedIP("")).assertErrorIs(t, lib127.ErrHostnameInvalid)
+
+	// Taken addresses are skipped.
+	path := filepath.Join(t.TempDir(), "hosts")
+	requireNoError(t, os.WriteFile(path, []byte(hashedIP+" taken.test\n"), 0o600))
+	h = openHostsFile(t, path, lib127.WithAllocator(lib127.HashAllocator{}))
+	call(h.Map("hashed.test")).assertIP(t, "127.104.254.135")
+}
+
+func TestAllocators(t *testing.T) {
+	t.Parallel()
+
+	h := openHostsFile(t, testdata.HostsFile(t),
+		lib127.WithAllocator(lib127.SequentialAllocator{}))
+	call(h.Map("a.test")).assertIP(t, "127.0.0.2")
+	call(h.Map("b.test")).assertIP(t, "127.0.0.5")
+
+	custom := allocatorFunc(func(
+		hostname string, _ lib127.Pool, _ func(netip.Addr) bool,
+	) (netip.Addr, error) {
+		if hostname == "fail.test" {
+			return netip.Addr{}, errors.New("custom allocator failure")
+		}
+		return netip.MustParseAddr("127.1.2.3"), nil
+	})
+	h = openHostsFile(t, testdata.HostsFile(t), lib127.WithAllocator(custom))
+	call(h.Map("custom.test")).assertIP(t, "127.1.2.3")
+	if _, err := h.Map("fail.test"); err == nil {
+		t.Error("want allocator error, got nil")
+	}
+}
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+
+	pool, err := lib127.ParsePool("127.42.0.0/30")
+	requireNoError(t, err)
+	if s := pool.String(); s != "127.42.0.1-127.42.0.2" {
+		t.Errorf("unexpected pool: %s", s)
+	}
+
+	h := openHostsFile(t, testdata.HostsFile(t),
+		lib127.WithAllocator(lib127.SequentialAllocator{}), lib127.WithPool(pool))
+	call(h.Map("a.test")).assertIP(t, "127.42.0.1")
+	call(h.Map("b.test")).assertIP(t, "127.42.0.2")
+	call(h.Map("c.test")).assertErrorIs(t, lib127.ErrNoFreeAddress)
+	call(h.RandomIP()).assertErrorIs(t, lib127.ErrNoFreeAddress)
+
+	// Localhost is never allocated.
+	pool, err = lib127.ParsePool("127.0.0.0/31")
+	requireNoError(t, err)
+	path := filepath.Join(t.TempDir(), "hosts")
+	requireNoError(t, os.WriteFile(path, nil, 0o600))
+	h = openHostsFile(t, path, lib127.WithPool(pool))
+	call(h.Map("a.test")).assertIP(t, "127.0.0.0")
+	call(h.Map("b.test")).assertErrorIs(t, lib127.ErrNoFreeAddress)
+
+	// The last free address is found, even if random attempts fail.
+	pool, err = lib127.ParsePool("127.42.1.0/24")
+	requireNoError(t, err)
+	var content strings.Builder
+	for i := 1; i < 254; i++ {
+		fmt.Fprintf(&content, "127.42.1.%d host%d.test\n", i, i)
+	}
+	requireNoError(t, os.WriteFile(path, []byte(content.String()), 0o600))
+	requireNoError(t, h.Close())
+	h = openHostsFile(t, path, lib127.WithPool(pool))
+	call(h.Map("last.test")).assertIP(t, "127.42.1.254")
+	call(h.Map("none.test")).assertErrorIs(t, lib127.ErrNoFreeAddress)
+
+	for _, cidr := range []string{"10.0.0.0/8", "0.0.0.0/0", "2001:db8::/32", "127.0.0.1"} {
+		_, err := lib127.ParsePool(cidr)
+		output{err: err}.assertErrorIs(t, lib127.ErrInvalidPool)
+	}
+
+	_, err = lib127.Open(testdata.HostsFile(t), lib127.WithPool(lib127.Pool{}))
+	output{err: err}.assertErrorIs(t, lib127.ErrInvalidPool)
+}
+
+func TestIPv6(t *testing.T) {
+	t.Parallel()
+
+	path := testdata.HostsFile(t)
+	h := openHostsFile(t, path, lib127.WithFamily(lib127.IPv6))
+	call(h.Map("six.test")).assertIP(t, "::1")
+	call(h.IP("six.test")).assertIP(t, "::1")
+	call(h.IP("localhost")).assertIP(t, "::1")
+	call(h.Unmap("six.test")).assertIP(t, "::1")
+	requireNoError(t, h.Close())
+
+	pool, err := lib127.ParsePool("fd7f::/120")
+	requireNoError(t, err)
+	h = openHostsFile(t, path, lib127.WithFamily(lib127.DualStack), lib127.WithPool(pool),
+		lib127.WithAllocator(lib127.SequentialAllocator{}))
+	call(h.Map("dual.test")).assertIP(t, "127.0.0.2")
+	call(h.Map("dual.test")).assertIP(t, "127.0.0.2")
+	ips, err := h.IPs("dual.test")
+	if err != nil || !reflect.DeepEqual(ips, []string{"127.0.0.2", "fd7f::1"}) {
+		t.Errorf("want dual-stack IPs, got: %v, %v", ips, err)
+	}
+	if got := h.Mappings(lib127.ScopeLoopback); len(got) != 5 || got[4].IP != "fd7f::1" {
+		t.Errorf("want IPv6 mapping in loopback scope, got: %v", got)
+	}
+
+	// Only the missing address is added to a hostname mapped to one of them.
+	call(h.Map("loopback.test")).assertIP(t, "127.0.0.3")
+	if ips, err := h.IPs("loopback.test"); err != nil ||
+		!reflect.DeepEqual(ips, []string{"127.0.0.3", "fd7f::2"}) {
+		t.Errorf("want IPv6 address added, got: %v, %v", ips, err)
+	}
+	requireNoError(t, h.Save())
+	requireNoError(t, h.Close())
+
+	h = openHostsFile(t, path, lib127.WithFamily(lib127.IPv6))
+	call(h.IP("dual.test")).assertIP(t, "fd7f::1")
+	call(h.Unmap("dual.test")).assertIP(t, "fd7f::1")
+	if ips, err := h.IPs("dual.test"); err != nil || len(ips) != 0 {
+		t.Errorf("want no IPs, got: %v, %v", ips, err)
+	}
+}
+
+func TestMapTo(t *testing.T) {
+	t.Parallel()
+
+	path := testdata.HostsFile(t)
+	h := openHostsFile(t, path)
+	assertReplaced(t, h, "new.test", "127.0.0.5")
+	call(h.IP("new.test")).assertIP(t, "127.0.0.5")
+	assertReplaced(t, h, "new.test", "127.0.0.5")
+	assertReplaced(t, h, "new.test", "127.0.0.6", lib127.Mapping{
+		IP: "127.0.0.5", Hostnames: []string{"new.test"}, Managed: true,
+	})
+	assertReplaced(t, h, "new.test", "fd7f::1")
+	assertReplaced(t, h, "shared.test", "127.0.0.1")
+	assertReplaced(t, h, "shared6.test", "::1")
+
+	ips, err := h.IPs("new.test")
+	if err != nil || !reflect.DeepEqual(ips, []string{"127.0.0.6", "fd7f::1"}) {
+		t.Errorf("want IPs of both versions, got: %v, %v", ips, err)
+	}
+
+	_, err = h.MapTo("other.test", "127.0.0.3")
+	call("", err).assertErrorIs(t, lib127.ErrIPInUse)
+	_, err = h.MapTo("unmanaged.test", "127.0.0.7")
+	call("", err).assertErrorIs(t, lib127.ErrNotManaged)
+	_, err = h.MapTo("public.test", "93.184.216.34")
+	call("", err).assertErrorIs(t, lib127.ErrInvalidIP)
+	_, err = h.MapTo("bad.test", "127.0.0")
+	call("", err).assertErrorIs(t, lib127.ErrInvalidIP)
+	_, err = h.MapTo("localhost", "127.0.0.8")
+	call("", err).assertErrorIs(t, lib127.ErrCannotRemapLocalhost)
+	_, err = h.MapTo("foo bar", "127.0.0.8")
+	call("", err).assertErrorIs(t, lib127.ErrHostnameInvalid)
+	requireNoError(t, h.Save())
+	requireNoError(t, h.Close())
+
+	h = openHostsFile(t, path, lib127.WithForce(true))
+	assertReplaced(t, h, "other.test", "127.0.0.3", lib127.Mapping{
+		IP: "127.0.0.3", Hostnames: []string{"loopback.test"}, Line: 10, Managed: true,
+	})
+	assertReplaced(t, h, "unmanaged.test", "127.0.0.7", lib127.Mapping{
+		IP: "127.0.0.4", Hostnames: []string{"unmanaged.test"}, Line: 2,
+	})
+	call(h.IP("loopback.test")).assertIP(t, "")
+	call(h.IP("other.test")).assertIP(t, "127.0.0.3")
+	call(h.IP("new.test")).assertIP(t, "127.0.0.6")
+	call(h.IP("localhost")).assertIP(t, "127.0.0.1")
+	if got := h.Mappings(lib127.ScopeAll); got[1].IP != "93.184.216.34" {
+		t.Errorf("want unmanaged record removed, got: %v", got)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	t.Parallel()
+
+	path := testdata.HostsFile(t)
+	h := openHostsFile(t, path)
+	call(h.Alias("loopback.test", "api.loopback.test")).assertIP(t, "127.0.0.3")
+	call(h.Alias("loopback.test", "api.loopback.test")).assertIP(t, "127.0.0.3")
+	call(h.Map("www.loopback.test")).assertIP(t, pseudoRndIP1)
+	call(h.Alias("loopback.test", "www.loopback.test")).assertIP(t, "127.0.0.3")
+	call(h.IP("www.loopback.test")).assertIP(t, "127.0.0.3")
+	if got := h.Mappings(lib127.ScopeLoopback); !reflect.DeepEqual(got[2].Hostnames,
+		[]string{"loopback.test", "api.loopback.test", "www.loopback.test"}) {
+		t.Errorf("want aliases in the same record, got: %v", got)
+	}
+
+	call(h.Alias("missing.test", "alias.test")).assertErrorIs(t, lib127.ErrNotMapped)
+	call(h.Alias("unmanaged.test", "alias.test")).assertErrorIs(t, lib127.ErrNotManaged)
+	call(h.Alias("loopback.test", "unmanaged.test")).assertErrorIs(t, lib127.ErrNotManaged)
+	call(h.Alias("loopback.test", "localhost")).assertErrorIs(t, lib127.ErrCannotRemapLocalhost)
+	call(h.Alias("loopback.test", "foo bar")).assertErrorIs(t, lib127.ErrHostnameInvalid)
+	requireNoError(t, h.Save())
+	requireNoError(t, h.Close())
+
+	// Unmapping an alias keeps the address mapped to the other names.
+	h = openHostsFile(t, path)
+	call(h.Unmap("api.loopback.test")).assertIP(t, "127.0.0.3")
+	call(h.Unmap("loopback.test")).assertIP(t, "127.0.0.3")
+	call(h.IP("www.loopback.test")).assertIP(t, "127.0.0.3")
+	call(h.Map("new.test")).assertIP(t, pseudoRndIP1)
+}
+
+func TestWildcard(t *testing.T) {
+	t.Parallel()
+
+	path := testdata.HostsFile(t)
+	h := openHostsFile(t, path)
+	call(h.MapWildcard("*.loopback.test")).assertIP(t, "127.0.0.3")
+	call(h.MapWildcard("*.loopback.test")).assertIP(t, "127.0.0.3")
+	call(h.MapWildcard("*.api.loopback.test")).assertIP(t, pseudoRndIP1)
+	call(h.MapWildcard("*.new.test")).assertIP(t, pseudoRndIP2)
+	call(h.MapWildcard("loopback.test")).assertErrorIs(t, lib127.ErrHostnameInvalid)
+	call(h.MapWildcard("*.foo bar")).assertErrorIs(t, lib127.ErrHostnameInvalid)
+	call(h.Map("unmapped.test")).assertIP(t, pseudoRndIP3)
+	requireNoError(t, h.Save())
+	requireNoError(t, h.Close())
+
+	h = openHostsFile(t, path)
+	for hostname, want := range map[string][]string{
+		"loopback.test":          {"127.0.0.3"},
+		"www.loopback.test":      {"127.0.0.3"},
+		"a.b.loopback.test":      {"127.0.0.3"},
+		"v1.api.loopback.test":   {pseudoRndIP1},
+		"api.loopback.test":      {"127.0.0.3"},
+		"x.new.test":             {pseudoRndIP2},
+		"new.test":               nil,
+		"unrelated.test":         nil,
+		"xloopback.test":         nil,
+		"localhost":              {"127.0.0.1", "::1"},
+		"WWW.Loopback.Test":      {"127.0.0.3"},
+		"unmanaged.test":         {"127.0.0.4"},
+		"www.unmanaged.test":     nil,
+		"www.xn--hello-ck1hg65u": nil,
+	} {
+		if got, err := h.Resolve(hostname); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("Resolve(%q): want: %v, got: %v, %v", hostname, want, got, err)
+		}
+	}
+	if got := h.Wildcards(lib127.ScopeAll); len(got) != 3 || got[2].Hostnames[0] != "*.new.test" {
+		t.Errorf("want wildcards, got: %v", got)
+	}
+
+	call(h.UnmapWildcard("*.loopback.test")).assertIP(t, "127.0.0.3")
+	call(h.UnmapWildcard("*.loopback.test")).assertIP(t, "")
+	if got, _ := h.Resolve("www.loopback.test"); got != nil {
+		t.Errorf("want unmapped wildcard, got: %v", got)
+	}
+
+	b, err := os.ReadFile(path)
+	requireNoError(t, err)
+	if got := string(b); !strings.Contains(got,
+		"# "+pseudoRndIP2+" *.new.test\n# END 127 MANAGED BLOCK\n") {
+		t.Errorf("want wildcards in managed block, got: %q", got)
+	}
+}
+
+func assertReplaced(
+	t *testing.T, h *lib127.Hosts, hostname, ip string, want ...lib127.Mapping,
+) {
+	t.Helper()
+
+	replaced, err := h.MapTo(hostname, ip)
+	requireNoError(t, err)
+	if !reflect.DeepEqual(replaced, want) {
+		t.Errorf("want replaced mappings: %v, got: %v", want, replaced)
+	}
+}
+
+type allocatorFunc func(string, lib127.Pool, func(netip.Addr) bool) (netip.Addr, error)
+
+func (f allocatorFunc) Allocate(
+	hostname string, pool lib127.Pool, used func(netip.Addr) bool,
+) (netip.Addr, error) {
+	return f(hostname, pool, used)
+}
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	path := testdata.HostsFile(t)
+	h := openHostsFile(t, path)
+
+	// A successful transaction is saved.
+	requireNoError(t, h.Update(func(tx *lib127.Tx) error {
+		call(tx.Map("a.test")).assertIP(t, pseudoRndIP1)
+		call(tx.Map("b.test")).assertIP(t, pseudoRndIP2)
+		call(tx.Unmap("loopback.test")).assertIP(t, "127.0.0.3")
+		return nil
+	}))
+	call(h.IP("b.test")).assertIP(t, pseudoRndIP2)
+
+	// A failed transaction is rolled back.
+	err := h.Update(func(tx *lib127.Tx) error {
+		call(tx.Map("c.test")).assertIP(t, pseudoRndIP3)
+		call(tx.Unmap("a.test")).assertIP(t, pseudoRndIP1)
+		_, _ = tx.Map("invalid hostname")
+		return nil
+	})
+	output{err: err}.assertErrorIs(t, lib127.ErrHostnameInvalid)
+	call(h.IP("a.test")).assertIP(t, pseudoRndIP1)
+	call(h.IP("c.test")).assertIP(t, "")
+
+	requireNoError(t, h.Close())
+	h = openHostsFile(t, path)
+	call(h.IP("a.test")).assertIP(t, pseudoRndIP1)
+	call(h.IP("b.test")).assertIP(t, pseudoRndIP2)
+	call(h.IP("c.test")).assertIP(t, "")
+	call(h.IP("loopback.test")).assertIP(t, "")
+}
+
+func TestLock(t *testing.T) {
+	t.Parallel()
+
+	path := testdata.HostsFile(t)
+	h := openHostsFile(t, path)
+
+	_, err := lib127.Open(path, lib127.WithLockTimeout(0))
+	output{err: err}.assertErrorIs(t, lib127.ErrLocked)
+
+	// Mappings made concurrently should not be lost once the lock is released.
+	done := make(chan *lib127.Hosts)
+	go func() {
+		h, err := lib127.Open(path)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		done <- h
+	}()
+
+	call(h.Map("first.test")).assertIP(t, pseudoRndIP1)
+	requireNoError(t, h.Save())
+	requireNoError(t, h.Close())
+
+	if h = <-done; h != nil {
+		call(h.IP("first.test")).assertIP(t, pseudoRndIP1)
+		requireNoError(t, h.Close())
+	}
+}
+
 func TestFSError(t *testing.T) {
 	t.Parallel()
 
@@ -66,23 +756,88 @@ func TestFSError(t *testing.T) {
 	}
 }
 
+func TestParseError(t *testing.T) {
+	t.Parallel()
+
+	h := openHostsFile(t, testdata.Fixture(t, "malformed.hosts"))
+	if got := len(h.Warnings()); got != 4 {
+		t.Errorf("want 4 warnings, got: %v", h.Warnings())
+	}
+	call(h.IP("loopback.test")).assertIP(t, "127.0.0.3")
+	call(h.IP("broken.test")).assertIP(t, "")
+
+	path := filepath.Join(t.TempDir(), "hosts")
+	requireNoError(t, os.WriteFile(path, []byte("# BEGIN 127 MANAGED BLOCK\n"), 0o600))
+	_, err := lib127.Open(path)
+	var parseErr *lib127.ParseError
+	output{err: err}.assertErrorAs(t, &parseErr)
+	if parseErr.File != path || parseErr.Line != 1 {
+		t.Errorf("unexpected parse error: %+v", parseErr)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	h := openHostsFile(t, testdata.Fixture(t, "problems.hosts"))
+	want := []string{
+		"2: error: localhost mapped to non-loopback address 192.168.1.10",
+		"2: error: localhost mapped to 192.168.1.10, but to 127.0.0.1 on line 1 first",
+		"4: warning: 127.0.0.4 is also assigned on line 3",
+		"8: warning: 127.0.0.5 is also assigned on line 7",
+		"8: warning: db.test mapped to 127.0.0.5 again, first on line 7 (fixable)",
+		"9: error: db.test mapped to 127.0.0.6, but to 127.0.0.5 on line 7 first (fixable)",
+		"10: warning: Upper.Test is looked up as upper.test",
+		`11: error: invalid hostname: adapt "bad_name.test": idna: disallowed rune U+005F`,
+		`13: error: missing hostname: "127.0.0.9"`,
+	}
+	assertProblems(t, h.Check(), want)
+
+	fixed, err := h.Fix()
+	requireNoError(t, err)
+	assertProblems(t, fixed, want[4:6])
+	assertProblems(t, h.Check(), []string{want[0], want[1], want[2], want[6], want[7], want[8]})
+	call(h.IP("db.test")).assertIP(t, "127.0.0.5")
+	call(h.IP("api.test")).assertIP(t, "127.0.0.6")
+}
+
 const (
-	pseudoRndIP1 = "127.10.87.204"
-	pseudoRndIP2 = "127.174.217.245"
-	pseudoRndIP3 = "127.209.224.187"
-	pseudoRndIP4 = "127.99.234.97"
-	pseudoRndIP5 = "127.224.77.159"
+	pseudoRndIP1 = "127.82.253.254"
+	pseudoRndIP2 = "127.7.33.132"
+	pseudoRndIP3 = "127.101.79.24"
+	pseudoRndIP4 = "127.63.95.17"
+	pseudoRndIP5 = "127.154.98.31"
 )
 
+func assertProblems(t *testing.T, problems []lib127.Problem, want []string) {
+	t.Helper()
+
+	var got []string
+	for _, p := range problems {
+		s := fmt.Sprintf("%d: %s: %s", p.Line, p.Severity, p.Message)
+		if p.Fixable {
+			s += " (fixable)"
+		}
+		got = append(got, s)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Want problems: %q, got: %q", want, got)
+	}
+}
+
 func openHosts(t *testing.T) *lib127.Hosts {
-	h, err := lib127.Open(testdata.HostsFile(t))
-	requireNoError(t, err)
+	return openHostsFile(t, testdata.HostsFile(t))
+}
 
+func openHostsFile(t *testing.T, path string, opts ...lib127.Option) *lib127.Hosts {
 	// Ensure predictable results with a pseudo-random number generator.
-	r := rand.New(rand.NewSource(1))
-	h.SetRandFunc(func(max uint32) (uint32, error) {
-		return uint32(r.Int63n(int64(max))), nil
-	})
+	opts = append([]lib127.Option{
+		lib127.WithAllocator(lib127.RandomAllocator{Rand: rand.New(rand.NewSource(1))}),
+	}, opts...)
+
+	h, err := lib127.Open(path, opts...)
+	requireNoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
 
 	return h
 }
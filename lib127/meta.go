@@ -0,0 +1,143 @@
+package lib127
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lende/127/lib127/internal/hosts"
+)
+
+// Metadata keys of records. The expiry of a record is kept as metadata as well,
+// see WithTTL.
+const (
+	userKey    = "user"
+	tagKey     = "tag"
+	createdKey = "created"
+	noteKey    = "note"
+	expiresKey = "expires"
+)
+
+// Meta describes who made a mapping and why. It is kept in a machine-readable
+// comment trailing the record in the hosts file, such as:
+//
+//	127.83.4.19 billing.test # 127: created=2026-10-16T09:15:02Z tag=shop user=alice
+type Meta struct {
+	// User is the user that made the mapping.
+	User string `json:"user,omitempty"`
+
+	// Tag labels the mapping, such as with the name of a team or service.
+	Tag string `json:"tag,omitempty"`
+
+	// Created is the time the mapping was made.
+	Created *time.Time `json:"created,omitempty"`
+
+	// Note is a free-form description of the mapping.
+	Note string `json:"note,omitempty"`
+}
+
+// WithMeta makes Map and MapTo record meta with the mappings they make. Created
+// is set to the time of mapping, unless given. No metadata is recorded by
+// default.
+func WithMeta(meta Meta) Option {
+	return func(h *Hosts) {
+		h.meta = &meta
+	}
+}
+
+// SetMeta replaces the metadata of every record of hostname, keeping the expiry
+// and profile of the records. Empty metadata removes it.
+//
+// Returned hostname errors can be matched against ErrHostnameInvalid and
+// ErrHostnameIsIP. Returns an error matching ErrNotMapped if hostname is not
+// mapped, and ErrNotManaged if it is mapped outside the managed block.
+func (h *Hosts) SetMeta(hostname string, meta Meta) error {
+	name, err := hosts.AdaptHostname(hostname)
+	if err != nil {
+		return wrapError("set metadata", err)
+	}
+
+	var ips []string
+	for _, r := range h.file.Records() {
+		if !slices.Contains(r.Hostnames, name) {
+			continue
+		}
+		if !r.Managed {
+			return fmt.Errorf("lib127: set metadata of %q: %w", hostname, ErrNotManaged)
+		}
+		ips = append(ips, r.IP)
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("lib127: set metadata of %q: %w", hostname, ErrNotMapped)
+	}
+
+	for _, ip := range ips {
+		if err := h.file.SetMeta(name, ip, meta.encode()); err != nil {
+			return wrapError("set metadata", err)
+		}
+	}
+	h.changed = true
+	return nil
+}
+
+// annotate records the metadata, expiry and profile of a mapping of hostname to
+// ip made by Map or MapTo, if configured.
+func (h *Hosts) annotate(hostname, ip string) error {
+	meta := hosts.Meta{}
+	if h.meta != nil {
+		m := *h.meta
+		if m.Created == nil {
+			now := time.Now().UTC().Truncate(time.Second)
+			m.Created = &now
+		}
+		meta = m.encode()
+	}
+	if h.ttl > 0 {
+		meta[expiresKey] = formatTime(time.Now().Add(h.ttl))
+	}
+	if h.profile != "" {
+		meta[profileKey] = h.profile
+	}
+	if len(meta) == 0 {
+		return nil
+	}
+
+	if err := h.file.SetMeta(hostname, ip, meta); err != nil {
+		return wrapError("set metadata", err)
+	}
+	return nil
+}
+
+// encode returns the metadata of a record holding m. Empty fields are encoded
+// as empty values, which remove the keys from the record.
+func (m Meta) encode() hosts.Meta {
+	meta := hosts.Meta{userKey: m.User, tagKey: m.Tag, createdKey: "", noteKey: m.Note}
+	if m.Created != nil {
+		meta[createdKey] = formatTime(*m.Created)
+	}
+	return meta
+}
+
+// decodeMeta returns the metadata kept in the metadata of a record.
+func decodeMeta(meta hosts.Meta) Meta {
+	return Meta{
+		User:    meta[userKey],
+		Tag:     meta[tagKey],
+		Created: parseTime(meta[createdKey]),
+		Note:    meta[noteKey],
+	}
+}
+
+// formatTime formats times of metadata, in UTC with a precision of seconds.
+func formatTime(t time.Time) string {
+	return t.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
+
+// parseTime returns the time of a metadata value, or nil if it is not a time.
+func parseTime(s string) *time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,129 @@
+package lib127
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/lende/127/lib127/internal/hosts"
+)
+
+// profileKey is the metadata key of the profile of a record.
+const profileKey = "profile"
+
+// WithProfile adds the mappings made by Map and MapTo to the named profile.
+// Profiles group mappings, such as those of a project, so that they can be
+// listed, unmapped, exported or switched off together. Mappings belong to no
+// profile by default.
+func WithProfile(name string) Option {
+	return func(h *Hosts) {
+		h.profile = name
+	}
+}
+
+// Profiles returns the names of the profiles with mappings in the managed
+// block, switched off or not, in sorted order.
+func (h *Hosts) Profiles() []string {
+	var names []string
+	for _, r := range h.allRecords() {
+		name := r.Meta[profileKey]
+		if inProfile(name)(r) && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ProfileMappings returns the mappings of the named profile, followed by those
+// that are switched off.
+func (h *Hosts) ProfileMappings(name string) []Mapping {
+	var mappings []Mapping
+	for _, r := range h.allRecords() {
+		if inProfile(name)(r) {
+			mappings = append(mappings, newMapping(r))
+		}
+	}
+	return mappings
+}
+
+// UnmapProfile unmaps every mapping of the named profile, including those that
+// are switched off, and returns them. Other mappings are not touched.
+func (h *Hosts) UnmapProfile(name string) []Mapping {
+	return h.changeProfile(h.file.RemoveRecords, name)
+}
+
+// DisableProfile switches off the mappings of the named profile, and returns
+// them. Their records are commented out, and their IP addresses stay reserved,
+// so that they can be switched back on by EnableProfile.
+func (h *Hosts) DisableProfile(name string) []Mapping {
+	return h.changeProfile(h.file.DisableRecords, name)
+}
+
+// EnableProfile switches the mappings of the named profile back on, and returns
+// them. Hostnames that were mapped again meanwhile end up mapped twice, which
+// is reported by Check.
+func (h *Hosts) EnableProfile(name string) []Mapping {
+	return h.changeProfile(h.file.EnableRecords, name)
+}
+
+// ExportProfile returns the records of the named profile as lines of a hosts
+// file, along with their metadata. Switched off records are commented out.
+func (h *Hosts) ExportProfile(name string) string {
+	var b strings.Builder
+	for _, r := range h.allRecords() {
+		if !inProfile(name)(r) {
+			continue
+		}
+		if r.Disabled {
+			b.WriteString("# ")
+		}
+		b.WriteString(r.IP + " " + strings.Join(r.Hostnames, " ") + " " + r.Meta.String() + "\n")
+	}
+	return b.String()
+}
+
+// changeProfile changes the records of the named profile with fn, and returns
+// their mappings.
+func (h *Hosts) changeProfile(fn func(func(hosts.Record) bool) []hosts.Record,
+	name string,
+) []Mapping {
+	var mappings []Mapping
+	for _, r := range fn(inProfile(name)) {
+		mappings = append(mappings, newMapping(r))
+	}
+	if len(mappings) > 0 {
+		h.changed = true
+	}
+	return mappings
+}
+
+// disabledIP returns the IP of hostname among the records that are switched off,
+// as returned by IP, or an empty string if there is none.
+func (h *Hosts) disabledIP(hostname string) string {
+	adaptedName, err := hosts.AdaptHostname(hostname)
+	if err != nil {
+		return ""
+	}
+
+	var ips []string
+	for _, r := range h.file.Disabled() {
+		if slices.Contains(r.Hostnames, adaptedName) {
+			ips = append(ips, r.IP)
+		}
+	}
+	return h.preferredIP(ips)
+}
+
+// allRecords returns the records of the hosts file, followed by the records
+// that are switched off.
+func (h *Hosts) allRecords() []hosts.Record {
+	return append(h.file.Records(), h.file.Disabled()...)
+}
+
+// inProfile returns a function reporting whether a record of the managed block
+// belongs to the named profile.
+func inProfile(name string) func(hosts.Record) bool {
+	return func(r hosts.Record) bool {
+		return name != "" && r.Managed && r.Meta[profileKey] == name
+	}
+}